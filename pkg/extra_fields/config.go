@@ -0,0 +1,43 @@
+package extra_fields
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	// Policy for extra fields already present in events: keep, overwrite or prefix. Defaults to overwrite
+	Conflicts string `yaml:"conflicts"`
+	// Prefix of extra fields added by the prefix policy, extra_ by default
+	ConflictPrefix string `yaml:"conflict_prefix"`
+	// Topic events which are not JSON objects are routed to, malformed by default
+	InvalidMessagesTopic string `yaml:"invalid_messages_topic"`
+	// Server receive time, request ID and clock skew fields, not added if nil
+	Timestamps *TimestampConfig `yaml:"timestamps"`
+	// Profile for topics missing in Topics
+	Default ProfileConfig            `yaml:"default"`
+	Topics  map[string]ProfileConfig `yaml:"topics"`
+}
+
+type ProfileConfig struct {
+	// Keys of the extra fields added to events, e.g. from_country, host. All fields if empty
+	Fields []string `yaml:"fields"`
+	// Output keys by field key, dot separated keys are placed in nested objects
+	Names map[string]string `yaml:"names"`
+	// Dot separated object fields without a name are placed in, e.g. geo. Top level if empty
+	Nest string `yaml:"nest"`
+}
+
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
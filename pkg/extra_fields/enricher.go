@@ -0,0 +1,129 @@
+package extra_fields
+
+import (
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/anchorfree/data-go/pkg/geo"
+)
+
+// Enricher computes extra fields of requests from the provider and the geo set
+type Enricher struct {
+	geoSet   *geo.Geo
+	provider Provider
+	proxies  *TrustedProxies
+	// optional, user agent fields are not set without it
+	userAgents *UserAgentParser
+	// optional, timestamp fields are not set without it
+	timestamps *Timestamps
+}
+
+// defaultEnricher is used by iterators created without an enricher, Init configures it
+var defaultEnricher = NewEnricher(nil)
+
+func NewEnricher(geoSet *geo.Geo) *Enricher {
+	return &Enricher{geoSet: geoSet}
+}
+
+// WithProvider sets the source of locations and networks of addresses
+func (e *Enricher) WithProvider(provider Provider) *Enricher {
+	e.provider = provider
+	return e
+}
+
+// WithTrustedProxies resolves client addresses through the trusted proxies,
+// without them GetIPAdress is used
+func (e *Enricher) WithTrustedProxies(proxies *TrustedProxies) *Enricher {
+	e.proxies = proxies
+	return e
+}
+
+// WithUserAgentParser makes the enricher add the client software of requests
+func (e *Enricher) WithUserAgentParser(parser *UserAgentParser) *Enricher {
+	e.userAgents = parser
+	return e
+}
+
+// WithTimestamps makes the enricher add the server receive time, the request ID and the clock skew
+func (e *Enricher) WithTimestamps(timestamps *Timestamps) *Enricher {
+	e.timestamps = timestamps
+	return e
+}
+
+// WithDBs makes the enricher use opened geoip2 databases, either may be nil
+func (e *Enricher) WithDBs(cityDB *geoip2.Reader, ispDB *geoip2.Reader) *Enricher {
+	return e.WithProvider(NewMaxMindProvider(cityDB, ispDB))
+}
+
+// FromFiles opens the geoip2 databases and reloads them on change
+func (e *Enricher) FromFiles(geoip2CityPath string, geoip2IspPath string) error {
+	provider, err := OpenMaxMindProvider(geoip2CityPath, geoip2IspPath)
+	if err != nil {
+		return err
+	}
+	e.provider = provider
+	return nil
+}
+
+// Close releases the provider if it watches files
+func (e *Enricher) Close() error {
+	if closer, ok := e.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ExtraFields returns extra fields of the request
+func (e *Enricher) ExtraFields(req *http.Request) *ExtraFields {
+	fields := &ExtraFields{enricher: e}
+	fields.GeoOrigin(req)
+	fields.CloudFront = IsCloudfront(req)
+	fields.Host = GetNginxHostname(req)
+	if e.timestamps != nil {
+		fields.ServerTs, fields.RequestID = e.timestamps.requestFields(req)
+	}
+	if e.userAgents != nil {
+		fields.UserAgent(e.userAgents.Parse(req))
+	}
+	return fields
+}
+
+// clientIP returns the client address and its source, the source is only reported with trusted proxies
+func (e *Enricher) clientIP(req *http.Request) (net.IP, string) {
+	if e.proxies == nil {
+		return GetIPAdress(req), ""
+	}
+	return e.proxies.ClientIP(req)
+}
+
+func (e *Enricher) city(ip net.IP) (*CityRecord, error) {
+	if e.provider == nil {
+		return nil, errNoDatabase
+	}
+	return e.provider.LookupCity(ip)
+}
+
+func (e *Enricher) asn(ip net.IP) (*ASNRecord, error) {
+	if e.provider == nil {
+		return nil, errNoDatabase
+	}
+	return e.provider.LookupASN(ip)
+}
+
+// geoLabel returns the geo set label of the IP
+func (e *Enricher) geoLabel(ip net.IP) string {
+	if e.geoSet == nil {
+		return geo.DefaultValue
+	}
+	return e.geoSet.Get(ip.String())
+}
+
+func (e *Enricher) geoMetadata(ip net.IP) map[string]string {
+	if e.geoSet == nil {
+		return nil
+	}
+	return e.geoSet.Metadata(ip.String())
+}
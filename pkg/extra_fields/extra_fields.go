@@ -9,8 +9,6 @@ import (
 
 	"github.com/anchorfree/data-go/pkg/logger"
 	"github.com/anchorfree/data-go/pkg/utils"
-
-	geoip2 "github.com/oschwald/geoip2-golang"
 )
 
 type ExtraFields struct {
@@ -27,15 +25,51 @@ type ExtraFields struct {
 	FromISP       string  `json:"from_isp,omitempty"`
 	FromOrgName   string  `json:"from_org_name,omitempty"`
 	Region        string  `json:"from_region,omitempty"`
+	// Where the client address was taken from, see IPSource constants. Set with trusted proxies only
+	IPSource string `json:"from_ip_source,omitempty"`
+	// Server receive time in milliseconds and the request ID, set if the enricher has timestamps
+	ServerTs  int64  `json:"server_ts,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Client software, set if the enricher has a user agent parser
+	UAOS          string `json:"ua_os,omitempty"`
+	UAOSVersion   string `json:"ua_os_version,omitempty"`
+	UADeviceClass string `json:"ua_device_class,omitempty"`
+	UABrowser     string `json:"ua_browser,omitempty"`
+	UAApp         string `json:"ua_app,omitempty"`
+	UAAppVersion  string `json:"ua_app_version,omitempty"`
+	// Extra columns of the geo set networks containing the IP, e.g. datacenter, provider, region
+	GeoMetadata map[string]string `json:"from_geo_metadata,omitempty"`
 
-	cityDBRec *geoip2.City
+	cityRec  *CityRecord
+	enricher *Enricher
+}
+
+// getEnricher returns the enricher of the fields, the default one for fields created by new(ExtraFields)
+func (f *ExtraFields) getEnricher() *Enricher {
+	if f.enricher == nil {
+		return defaultEnricher
+	}
+	return f.enricher
+}
+
+// UserAgent sets the client software fields
+func (f *ExtraFields) UserAgent(ua UserAgent) {
+	f.UAOS = ua.OS
+	f.UAOSVersion = ua.OSVersion
+	f.UADeviceClass = ua.DeviceClass
+	f.UABrowser = ua.Browser
+	f.UAApp = ua.App
+	f.UAAppVersion = ua.AppVersion
 }
 
 func (f *ExtraFields) GeoOrigin(req *http.Request) {
-	ip := GetIPAdress(req)
+	enricher := f.getEnricher()
+	var ip net.IP
+	ip, f.IPSource = enricher.clientIP(req)
 
 	_ = f.fromISP(req, ip)
-	if geoSet.Get(ip.String()) == "af" && IsCloudfront(req) == 1 {
+	f.GeoMetadata = enricher.geoMetadata(ip)
+	if enricher.geoLabel(ip) == "af" && IsCloudfront(req) == 1 {
 		return
 	}
 
@@ -54,29 +88,25 @@ func (f *ExtraFields) GeoOrigin(req *http.Request) {
 	}
 }
 
-// cityDB.City() wrapper function, to cache the result.
+// Provider LookupCity wrapper function, to cache the result.
 // WARNING: It's suggested that IP argument will be the same within same ExtraFields set!
-func (f *ExtraFields) GetCityDBRecord(ip net.IP) (*geoip2.City, error) {
-	var (
-		err error
-		rec *geoip2.City
-	)
-	if f.cityDBRec == nil {
-		cityMux.RLock()
-		rec, err = cityDB.City(ip)
-		cityMux.RUnlock()
+func (f *ExtraFields) GetCityDBRecord(ip net.IP) (*CityRecord, error) {
+	if f.cityRec == nil {
+		rec, err := f.getEnricher().city(ip)
 		if err != nil {
 			return nil, err
 		}
-		f.cityDBRec = rec
+		f.cityRec = rec
 	}
-	return f.cityDBRec, err
+	return f.cityRec, nil
 }
 
 func GetNginxHostname(req *http.Request) string {
 	return req.Header.Get("host")
 }
 
+// GetIPAdress returns the first address of X-Real-Ip or X-Forwarded-For outside of special-purpose ranges,
+// the headers are trusted whoever sent them, see TrustedProxies
 func GetIPAdress(req *http.Request) net.IP {
 	var realIP net.IP
 	var remoteAddr string
@@ -97,7 +127,7 @@ func GetIPAdress(req *http.Request) net.IP {
 				ip := strings.TrimSpace(addresses[i])
 				// header can contain spaces too, strip those out.
 				realIP = net.ParseIP(ip)
-				if !realIP.IsGlobalUnicast() || isPrivateSubnet(realIP) {
+				if !realIP.IsGlobalUnicast() || utils.IsSpecialPurpose(realIP) {
 					// bad address, go to next
 					continue
 				}
@@ -117,8 +147,8 @@ func (f *ExtraFields) coordinates(req *http.Request, ip net.IP) error {
 			logger.Get().Warnf("Could not get geoip cityDB record: %v", err)
 			return err
 		}
-		f.Latitude = geoRec.Location.Latitude
-		f.Longitude = geoRec.Location.Longitude
+		f.Latitude = geoRec.Latitude
+		f.Longitude = geoRec.Longitude
 	} else {
 		parts := strings.Split(afLatLong, ",")
 		if len(parts) != 2 {
@@ -153,7 +183,7 @@ func (f *ExtraFields) countryName(req *http.Request, ip net.IP) error {
 			logger.Get().Warnf("Error: %v, for ip: %s", err, ip.String())
 			return err
 		}
-		f.Country = record.Country.IsoCode
+		f.Country = record.Country
 		if f.Country != "" {
 			f.CountrySource = "geoip"
 		}
@@ -174,9 +204,9 @@ func (f *ExtraFields) cityName(req *http.Request, ip net.IP) error {
 			logger.Get().Warnf("Error: %v, for ip: %s", err, ip.String())
 			return err
 		}
-		f.City = record.City.Names["en"]
-		if afRegion == "" && len(record.Subdivisions) > 0 {
-			afRegion = record.Subdivisions[0].IsoCode
+		f.City = record.City
+		if afRegion == "" {
+			afRegion = record.Region
 		}
 		if f.City != "" {
 			f.CitySource = "geoip"
@@ -190,12 +220,10 @@ func (f *ExtraFields) cityName(req *http.Request, ip net.IP) error {
 }
 
 func (f *ExtraFields) fromISP(req *http.Request, ip net.IP) error {
-	var isp *geoip2.ISP
+	var isp *ASNRecord
 	var err error
 	if ip != nil {
-		ispMux.RLock()
-		isp, err = ispDB.ISP(ip)
-		ispMux.RUnlock()
+		isp, err = f.getEnricher().asn(ip)
 		if err != nil {
 			logger.Get().Warnf("Error: %v, for ip: %s", err, ip.String())
 		}
@@ -203,14 +231,14 @@ func (f *ExtraFields) fromISP(req *http.Request, ip net.IP) error {
 
 	fromASN := GetMatchingHeader(req.Header, "x_af_asn")
 	if fromASN == "" && isp != nil {
-		f.FromASN = strconv.FormatUint(uint64(isp.AutonomousSystemNumber), 10)
+		f.FromASN = strconv.FormatUint(uint64(isp.Number), 10)
 	} else {
 		f.FromASN = fromASN
 	}
 
 	fromASNDesc := GetMatchingHeader(req.Header, "x_af_asdescription")
 	if fromASNDesc == "" && isp != nil {
-		f.FromASDesc = isp.AutonomousSystemOrganization
+		f.FromASDesc = isp.Organization
 	} else {
 		f.FromASDesc = fromASNDesc
 	}
@@ -224,7 +252,7 @@ func (f *ExtraFields) fromISP(req *http.Request, ip net.IP) error {
 
 	fromOrgName := GetMatchingHeader(req.Header, "X_AF_ORGNAME")
 	if fromOrgName == "" && isp != nil {
-		f.FromOrgName = isp.Organization
+		f.FromOrgName = isp.OrgName
 	} else {
 		f.FromOrgName = fromOrgName
 	}
@@ -239,15 +267,6 @@ func IsCloudfront(req *http.Request) int {
 	return 0
 }
 
-var private24BitBlock net.IPNet = net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.IPv4Mask(255, 0, 0, 0)}
-var private20BitBlock net.IPNet = net.IPNet{IP: net.IPv4(172, 16, 0, 0), Mask: net.IPv4Mask(255, 240, 0, 0)}
-var private16BitBlock net.IPNet = net.IPNet{IP: net.IPv4(192, 168, 0, 0), Mask: net.IPv4Mask(255, 255, 0, 0)}
-
-// isPrivateSubnet - check to see if this ip is in a private subnet
-func isPrivateSubnet(IP net.IP) bool {
-	return private24BitBlock.Contains(IP) || private20BitBlock.Contains(IP) || private16BitBlock.Contains(IP)
-}
-
 func GetMatchingHeader(headers http.Header, key string) string {
 	res := headers.Get(key)
 	if res == "" {
@@ -1,6 +1,7 @@
 package extra_fields
 
 import (
+	"github.com/anchorfree/data-go/pkg/geo"
 	"github.com/stretchr/testify/assert"
 	"net"
 	"net/http"
@@ -52,6 +53,10 @@ func TestGetIPAddress(t *testing.T) {
 			name:     "Multiple X-Forwarded-For coma separated values",
 			request:  newRequest("74.115.4.68", "", localAddr, publicAddr1, publicAddr2, publicAddr3),
 			expected: publicAddr1,
+		}, {
+			name:     "Special-purpose X-Forwarded-For values",
+			request:  newRequest("", "", "100.64.1.1", "fd00::1", "fe80::1", "198.51.100.7", "64:ff9b::8.8.8.8", publicAddr2),
+			expected: publicAddr2,
 		}, {
 			name:     "Has X-Real-IP",
 			request:  newRequest("", publicAddr1),
@@ -65,3 +70,13 @@ func TestGetIPAddress(t *testing.T) {
 		assert.Equalf(t, net.ParseIP(v.expected).String(), ip.String(), "test: %s - ip does not match", v.name)
 	}
 }
+
+func TestEnricher_Independent(t *testing.T) {
+	req := &http.Request{RemoteAddr: "54.182.1.2:443", Header: http.Header{}}
+	aws := NewEnricher(geo.NewGeo().FromBytes([]byte("54.182.0.0/16 aws provider=amazon;")))
+	other := NewEnricher(geo.NewGeo().FromBytes([]byte("54.182.0.0/16 cdn provider=other;")))
+
+	assert.Equal(t, map[string]string{"provider": "amazon"}, aws.ExtraFields(req).GeoMetadata)
+	assert.Equal(t, map[string]string{"provider": "other"}, other.ExtraFields(req).GeoMetadata)
+	assert.Nil(t, NewEnricher(nil).ExtraFields(req).GeoMetadata)
+}
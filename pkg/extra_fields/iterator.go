@@ -1,8 +1,15 @@
 package extra_fields
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/valyala/fastjson"
 
 	"github.com/anchorfree/data-go/pkg/logger"
 	"github.com/anchorfree/data-go/pkg/types"
@@ -13,16 +20,53 @@ type EventIterator struct {
 	event          *types.Event
 	err            error
 	request        *http.Request
+	enricher       *Enricher
+	profiles       *Profiles
 	extraFields    []byte
 	extraFieldFunc map[string]func() interface{}
+	// keys of extraFieldFunc in order
+	funcKeys    []string
+	funcEntries []entry
+	// fields of the request, computed on the first event
+	fields *ExtraFields
+	// fields of the request and With fields by topic
+	rendered map[string]*topicFields
+	merger   *Merger
+	parser   fastjson.Parser
+	// arena holds values added to the current event
+	arena fastjson.Arena
 }
 
 var _ types.EventIterator = (*EventIterator)(nil)
 
+var emptyObject = []byte("{}")
+
+// topicFields are the extra fields of events of a topic
+type topicFields struct {
+	entries []entry
+	// size of the serialized fields
+	size int
+	// path of server_ts in events
+	serverTsPath []string
+}
+
+// NewIterator creates an iterator adding extra fields of the request to events
+// using the enricher configured by Init
 func NewIterator(eventIterator types.EventIterator, req *http.Request) *EventIterator {
+	return NewIteratorWithEnricher(eventIterator, req, defaultEnricher)
+}
+
+// NewIteratorWithEnricher creates an iterator adding extra fields of the request found by the enricher,
+// the one configured by Init is used if enricher is nil
+func NewIteratorWithEnricher(eventIterator types.EventIterator, req *http.Request, enricher *Enricher) *EventIterator {
+	if enricher == nil {
+		enricher = defaultEnricher
+	}
 	return &EventIterator{
 		iterator:       eventIterator,
 		request:        req,
+		enricher:       enricher,
+		merger:         DefaultMerger,
 		extraFields:    []byte(""),
 		extraFieldFunc: make(map[string]func() interface{}),
 	}
@@ -36,27 +80,99 @@ func (ei *EventIterator) Next() bool {
 
 	ei.event = ei.iterator.At()
 
-	fields := new(ExtraFields)
-	fields.GeoOrigin(ei.request)
-	fields.CloudFront = IsCloudfront(ei.request)
-	fields.Host = GetNginxHostname(ei.request)
-
-	extra, marshalErr := json.Marshal(fields)
-	if marshalErr != nil {
+	message := ei.event.Message
+	if len(bytes.TrimSpace(message)) == 0 {
+		// empty events get the extra fields only
+		message = emptyObject
+	}
+	root, err := parseObject(&ei.parser, message)
+	if err != nil {
+		logger.Get().Debugf("Could not add extra fields to %s: %v", ei.event, err)
+		ei.reroute()
+		return true
+	}
+	extra, err := ei.renderedFields(ei.event.Topic)
+	if err != nil {
+		ei.err = err
 		return false
 	}
 
-	ei.event.Message = AppendJsonExtraFields(ei.event.Message, extra)
-	if len(ei.extraFields) > 0 {
-		ei.event.Message = AppendJsonExtraFields(ei.event.Message, ei.extraFields)
+	ei.arena.Reset()
+	ei.merger.mergeObject(root.GetObject(), extra.entries, &ei.arena)
+	if len(ei.funcKeys) > 0 {
+		ei.merger.mergeObject(root.GetObject(), ei.renderExtraFieldsFunc(), &ei.arena)
 	}
-	if len(ei.extraFieldFunc) > 0 {
-		ei.event.Message = AppendJsonExtraFields(ei.event.Message, ei.renderExtraFieldsFunc())
+	if ei.enricher.timestamps != nil {
+		ei.enricher.timestamps.apply(root, extra.serverTsPath, ei.fields.ServerTs, &ei.arena, ei.merger)
 	}
+	ei.event.Message = root.MarshalTo(make([]byte, 0, len(ei.event.Message)+extra.size))
 
 	return true
 }
 
+// reroute sends the event to the invalid messages topic, prefixed with its topic like schema does
+func (ei *EventIterator) reroute() {
+	ei.event.Message = bytes.Join([][]byte{[]byte(ei.event.Topic), ei.event.Message}, []byte("\t"))
+	ei.event.Topic = ei.merger.invalidTopic
+}
+
+// renderedFields returns the fields of the request and With fields for events of the topic,
+// all events of the iterator share the request so they are computed once
+func (ei *EventIterator) renderedFields(topic string) (*topicFields, error) {
+	if extra, found := ei.rendered[topic]; found {
+		return extra, nil
+	}
+	if ei.fields == nil {
+		ei.fields = ei.enricher.ExtraFields(ei.request)
+	}
+	raw, err := ei.profile(topic).render(ei.fields)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := fastjson.ParseBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(ei.extraFields) > 0 {
+		with, err := fastjson.ParseBytes(ei.extraFields)
+		if err != nil {
+			return nil, err
+		}
+		// With fields win over request ones
+		DefaultMerger.mergeObject(extra.GetObject(), newEntries(with.GetObject()), nil)
+	}
+	if ei.rendered == nil {
+		ei.rendered = make(map[string]*topicFields)
+	}
+	fields := &topicFields{
+		entries:      newEntries(extra.GetObject()),
+		size:         len(extra.MarshalTo(nil)),
+		serverTsPath: ei.profile(topic).path("server_ts"),
+	}
+	ei.rendered[topic] = fields
+	return fields, nil
+}
+
+// WithMerger sets how extra fields are added to events, DefaultMerger is used by default
+func (ei *EventIterator) WithMerger(merger *Merger) *EventIterator {
+	ei.merger = merger
+	return ei
+}
+
+// WithProfiles selects extra fields of events and their keys according to the profile of the topic
+func (ei *EventIterator) WithProfiles(profiles *Profiles) *EventIterator {
+	ei.profiles = profiles
+	ei.rendered = nil
+	return ei
+}
+
+func (ei *EventIterator) profile(topic string) *Profile {
+	if ei.profiles == nil {
+		return DefaultProfile
+	}
+	return ei.profiles.Get(topic)
+}
+
 func (ei *EventIterator) At() *types.Event {
 	return ei.event
 }
@@ -71,6 +187,7 @@ func (ei *EventIterator) With(extra map[string]interface{}) *EventIterator {
 		logger.Get().Errorf("Could not marshal extra fields: %v", extra)
 	} else {
 		ei.extraFields = AppendJsonExtraFields(ei.extraFields, extraJson)
+		ei.rendered = nil
 	}
 	return ei
 }
@@ -83,18 +200,77 @@ func (ei *EventIterator) WithFuncUint64(key string, f func() uint64) *EventItera
 }
 
 func (ei *EventIterator) WithFunc(key string, f func() interface{}) *EventIterator {
+	if _, found := ei.extraFieldFunc[key]; !found {
+		ei.funcKeys = append(ei.funcKeys, key)
+		sort.Strings(ei.funcKeys)
+	}
 	ei.extraFieldFunc[key] = f
 	return ei
 }
 
-func (ei *EventIterator) renderExtraFieldsFunc() []byte {
-	extraFields := make(map[string]interface{})
-	for key, f := range ei.extraFieldFunc {
-		extraFields[key] = f()
+// renderExtraFieldsFunc returns the function extra fields of the event, built in the iterator arena
+func (ei *EventIterator) renderExtraFieldsFunc() []entry {
+	ei.funcEntries = ei.funcEntries[:0]
+	for _, key := range ei.funcKeys {
+		value := ei.extraFieldFunc[key]()
+		v, err := newValue(&ei.arena, value)
+		if err != nil {
+			logger.Get().Errorf("Could not marshal function extra field %s: %v", key, value)
+			continue
+		}
+		e := entry{key: key, value: v}
+		if v.Type() == fastjson.TypeObject {
+			e.entries = newEntries(v.GetObject())
+		}
+		ei.funcEntries = append(ei.funcEntries, e)
+	}
+	return ei.funcEntries
+}
+
+const (
+	maxInt = int64(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// newValue converts a Go value to JSON, scalars are built without marshalling
+func newValue(a *fastjson.Arena, value interface{}) (*fastjson.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return a.NewNull(), nil
+	case string:
+		return a.NewString(v), nil
+	case bool:
+		if v {
+			return a.NewTrue(), nil
+		}
+		return a.NewFalse(), nil
+	case int:
+		return a.NewNumberInt(v), nil
+	case int32:
+		return a.NewNumberInt(int(v)), nil
+	case int64:
+		if v >= minInt && v <= maxInt {
+			return a.NewNumberInt(int(v)), nil
+		}
+		return a.NewNumberString(strconv.FormatInt(v, 10)), nil
+	case uint32:
+		return a.NewNumberString(strconv.FormatUint(uint64(v), 10)), nil
+	case uint64:
+		if v <= uint64(maxInt) {
+			return a.NewNumberInt(int(v)), nil
+		}
+		return a.NewNumberString(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("unsupported value %v", v)
+		}
+		return a.NewNumberFloat64(v), nil
+	case json.Number:
+		return a.NewNumberString(string(v)), nil
 	}
-	renderedExtraFields, err := json.Marshal(extraFields)
+	raw, err := json.Marshal(value)
 	if err != nil {
-		logger.Get().Errorf("Could not marshal function extra fields: %v", extraFields)
+		return nil, err
 	}
-	return renderedExtraFields
+	return fastjson.ParseBytes(raw)
 }
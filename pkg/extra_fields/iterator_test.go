@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"net/http/httptest"
 	"testing"
 
@@ -11,6 +13,8 @@ import (
 	lor "github.com/anchorfree/data-go/pkg/line_offset_reader"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fastjson"
 )
 
 type EF struct {
@@ -63,14 +67,14 @@ func TestExtraFieldsFromHeaders(t *testing.T) {
 	req.Header.Set("x_af_asdescription", fromAsDesc)
 	req.Header.Set("x_af_ispname", fromIsp)
 	req.Header.Set("x_af_orgname", fromOrgName)
-	geoSet = geo.NewGeo().FromFile("geo-test.conf")
-	Init("test-data/test-data/GeoIP2-City-Test.mmdb", "test-data/test-data/GeoIP2-ISP-Test.mmdb", geoSet)
+	// no databases, fields come from headers
+	enricher := NewEnricher(geo.NewGeo().FromFile("geo-test.conf"))
 	extraFields := map[string]interface{}{
 		"server_ts": serverTs,
 		"client_ts": clientTs,
 	}
 	lineReader := lor.NewIterator(bytes.NewReader(raw), topic)
-	efi := NewIterator(lineReader, req).With(extraFields)
+	efi := NewIteratorWithEnricher(lineReader, req, enricher).With(extraFields)
 
 	for efi.Next() {
 		event := efi.At()
@@ -95,15 +99,16 @@ func TestExtraFieldsFromHeaders(t *testing.T) {
 
 func TestExtraFieldsFromIspDb(t *testing.T) {
 	// test with geo data from ISP mmdb
-	geoSet = geo.NewGeo().FromFile("geo-test.conf")
-	Init("test-data/test-data/GeoIP2-City-Test.mmdb", "test-data/test-data/GeoIP2-ISP-Test.mmdb", geoSet)
+	enricher := NewEnricher(geo.NewGeo().FromFile("geo-test.conf"))
+	require.NoError(t, enricher.FromFiles("test-data/test-data/GeoIP2-City-Test.mmdb", "test-data/test-data/GeoIP2-ISP-Test.mmdb"))
+	defer enricher.Close()
 	topic := "test"
 	path := fmt.Sprintf("/ula?report_type=%s", topic)
 	req := httptest.NewRequest("POST", path, bytes.NewReader([]byte("")))
 	testIP := "1.128.0.0"
 	req.RemoteAddr = testIP
 	lineReader := lor.NewIterator(bytes.NewReader(raw), topic)
-	efi := NewIterator(lineReader, req)
+	efi := NewIteratorWithEnricher(lineReader, req, enricher)
 	for efi.Next() {
 		event := efi.At()
 		var rec EF
@@ -118,15 +123,16 @@ func TestExtraFieldsFromIspDb(t *testing.T) {
 
 func TestExtraFieldsFromCityDb(t *testing.T) {
 	// test with geo data from ISP mmdb
-	geoSet = geo.NewGeo().FromFile("geo-test.conf")
-	Init("test-data/test-data/GeoIP2-City-Test.mmdb", "test-data/test-data/GeoIP2-ISP-Test.mmdb", geoSet)
+	enricher := NewEnricher(geo.NewGeo().FromFile("geo-test.conf"))
+	require.NoError(t, enricher.FromFiles("test-data/test-data/GeoIP2-City-Test.mmdb", "test-data/test-data/GeoIP2-ISP-Test.mmdb"))
+	defer enricher.Close()
 	topic := "test"
 	path := fmt.Sprintf("/ula?report_type=%s", topic)
 	req := httptest.NewRequest("POST", path, bytes.NewReader([]byte("")))
 	testIP := "81.2.69.160"
 	req.RemoteAddr = testIP
 	lineIter := lor.NewIterator(bytes.NewReader(raw), topic)
-	efi := NewIterator(lineIter, req)
+	efi := NewIteratorWithEnricher(lineIter, req, enricher)
 	for efi.Next() {
 		event := efi.At()
 		var rec EF
@@ -235,9 +241,99 @@ func TestExtraFieldsReader_WithFunc(t *testing.T) {
 	}
 }
 
+func TestNewValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `null`},
+		{"a\"b", `"a\"b"`},
+		{true, `true`},
+		{-42, `-42`},
+		{int64(-9007199254740993), `-9007199254740993`},
+		{uint64(18446744073709551615), `18446744073709551615`},
+		{1.5, `1.5`},
+		{json.Number("1e3"), `1e3`},
+		{map[string]interface{}{"a": []int{1}}, `{"a":[1]}`},
+	}
+	var a fastjson.Arena
+	for _, test := range tests {
+		v, err := newValue(&a, test.value)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, string(v.MarshalTo(nil)), "value %#v", test.value)
+	}
+	_, err := newValue(&a, math.NaN())
+	assert.Error(t, err)
+}
+
 func TestAppendJsonExtraFields(t *testing.T) {
 	assert.Equal(t, AppendJsonExtraFields([]byte{}, []byte{}), []byte{}, "Failed to append empty []byte{}")
 	assert.Equal(t, AppendJsonExtraFields([]byte{}, []byte(`{}`)), []byte(`{}`), "Failed to append empty json objects to []byte{}")
 	assert.Equal(t, AppendJsonExtraFields([]byte(`{}`), []byte(`{}`)), []byte(`{}`), "Failed to append empty json objects")
 	assert.Equal(t, AppendJsonExtraFields([]byte(`{}`), []byte{}), []byte(`{}`), "Failed to append empty json objects")
+	assert.Equal(t, `{"a":1}`, string(AppendJsonExtraFields([]byte(`{}`), []byte(`{"a":1}`))), "Failed to append to empty json object")
+	assert.Equal(t, `{"a":2,"b":3}`, string(AppendJsonExtraFields([]byte(`{"a":1}`), []byte(`{"a":2,"b":3}`))), "Failed to overwrite existing field")
+	assert.Equal(t, `[1]`, string(AppendJsonExtraFields([]byte(`[1]`), []byte(`{"a":1}`))), "Failed to keep non-object line")
+}
+
+// countingProvider counts lookups of the wrapped provider
+type countingProvider struct {
+	Provider
+	cities, asns int
+}
+
+func (p *countingProvider) LookupCity(ip net.IP) (*CityRecord, error) {
+	p.cities++
+	return p.Provider.LookupCity(ip)
+}
+
+func (p *countingProvider) LookupASN(ip net.IP) (*ASNRecord, error) {
+	p.asns++
+	return p.Provider.LookupASN(ip)
+}
+
+func TestEventIterator_EnrichesOncePerRequest(t *testing.T) {
+	provider := &countingProvider{Provider: &StaticProvider{
+		DefaultCity: &CityRecord{Country: "GB", City: "London"},
+		DefaultASN:  &ASNRecord{Number: 1221},
+	}}
+	req := httptest.NewRequest("POST", "/", nil)
+	lines := bytes.Repeat([]byte(`{"event":"test"}`+"\n"), 3)
+	efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, NewEnricher(nil).WithProvider(provider)).
+		With(map[string]interface{}{"with": 1})
+
+	events := 0
+	for efi.Next() {
+		events++
+		var rec map[string]interface{}
+		require.NoError(t, json.Unmarshal(efi.At().Message, &rec))
+		assert.Equal(t, "London", rec["from_city"])
+		assert.Equal(t, "1221", rec["from_asn"])
+		assert.Equal(t, float64(1), rec["with"])
+	}
+	assert.Equal(t, 3, events)
+	assert.Equal(t, 1, provider.cities)
+	assert.Equal(t, 1, provider.asns)
+}
+
+func BenchmarkEventIterator(b *testing.B) {
+	enricher := NewEnricher(nil).WithProvider(&StaticProvider{
+		DefaultCity: &CityRecord{Country: "GB", City: "London", Region: "ENG", Latitude: 51.5142, Longitude: -0.0931},
+		DefaultASN:  &ASNRecord{Number: 1221, Organization: "Telstra Pty Ltd", ISP: "Telstra Internet", OrgName: "Telstra Internet"},
+	})
+	req := httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "81.2.69.160:443"
+	for _, events := range []int{1, 100} {
+		lines := bytes.Repeat(append(raw, '\n'), events)
+		b.Run(fmt.Sprintf("events=%d", events), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, enricher).
+					With(map[string]interface{}{"server_ts": 1521800927956}).
+					WithFuncUint64("seq", func() uint64 { return uint64(i) })
+				for efi.Next() {
+				}
+			}
+		})
+	}
 }
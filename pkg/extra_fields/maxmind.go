@@ -0,0 +1,195 @@
+package extra_fields
+
+import (
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/anchorfree/data-go/pkg/logger"
+)
+
+const geoLite2ASN = "GeoLite2-ASN"
+
+// MaxMindProvider looks up addresses in geoip2 databases. The location database
+// is GeoIP2/GeoLite2 City or Country, the network one is GeoIP2 ISP or GeoLite2 ASN.
+type MaxMindProvider struct {
+	cityDB     *geoip2.Reader
+	asnDB      *geoip2.Reader
+	cityDBFile string
+	asnDBFile  string
+	cityMux    sync.RWMutex
+	asnMux     sync.RWMutex
+	watcher    *fsnotify.Watcher
+}
+
+var _ Provider = (*MaxMindProvider)(nil)
+
+// NewMaxMindProvider uses opened databases, either may be nil
+func NewMaxMindProvider(cityDB *geoip2.Reader, asnDB *geoip2.Reader) *MaxMindProvider {
+	return &MaxMindProvider{cityDB: cityDB, asnDB: asnDB}
+}
+
+// OpenMaxMindProvider opens the databases and reloads them on change, an empty path skips the database
+func OpenMaxMindProvider(cityPath string, asnPath string) (*MaxMindProvider, error) {
+	p := &MaxMindProvider{cityDBFile: cityPath, asnDBFile: asnPath}
+	var files []string
+	if cityPath != "" {
+		if err := p.loadCityDB(); err != nil {
+			return nil, err
+		}
+		files = append(files, cityPath)
+	}
+	if asnPath != "" {
+		if err := p.loadASNDB(); err != nil {
+			return nil, err
+		}
+		files = append(files, asnPath)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		logger.Get().Debugf("Watching %s file", file)
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+	p.watcher = watcher
+	go p.watch(watcher)
+	return p, nil
+}
+
+// Close stops watching the database files
+func (p *MaxMindProvider) Close() error {
+	if p.watcher == nil {
+		return nil
+	}
+	return p.watcher.Close()
+}
+
+func (p *MaxMindProvider) watch(watcher *fsnotify.Watcher) {
+	timeoutAfterLastEvent := 5 * time.Second
+	defer logger.Get().Infof("File watcher shutdown")
+	timers := map[string]*time.Timer{}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
+				if event.Name == p.cityDBFile || event.Name == p.asnDBFile {
+					logger.Get().Debugf("modified file (%v): %s", event.Op, event.Name)
+					if timer, found := timers[event.Name]; !found || !timer.Reset(timeoutAfterLastEvent) {
+						if found {
+							timers[event.Name].Stop()
+						}
+						switch event.Name {
+						case p.cityDBFile:
+							timers[event.Name] = time.AfterFunc(timeoutAfterLastEvent, func() { _ = p.loadCityDB() })
+						case p.asnDBFile:
+							timers[event.Name] = time.AfterFunc(timeoutAfterLastEvent, func() { _ = p.loadASNDB() })
+						}
+					}
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Get().Debugf("error: %v", err)
+		}
+	}
+}
+
+func (p *MaxMindProvider) loadCityDB() error {
+	logger.Get().Infof("Loading geoip2 City database from: %s", p.cityDBFile)
+	tmpDB, err := geoip2.Open(p.cityDBFile)
+	if err != nil {
+		logger.Get().Errorf("Error loading City database: %v", err)
+		return err
+	}
+	p.cityMux.Lock()
+	if p.cityDB != nil {
+		logger.Get().Debug("Closing old cityDB")
+		_ = p.cityDB.Close()
+	}
+	p.cityDB = tmpDB
+	p.cityMux.Unlock()
+	return nil
+}
+
+func (p *MaxMindProvider) loadASNDB() error {
+	logger.Get().Infof("Loading geoip2 ISP database from: %s", p.asnDBFile)
+	tmpDB, err := geoip2.Open(p.asnDBFile)
+	if err != nil {
+		logger.Get().Errorf("Error loading ISP database: %v", err)
+		return err
+	}
+	p.asnMux.Lock()
+	if p.asnDB != nil {
+		logger.Get().Debug("Closing old ispDB")
+		_ = p.asnDB.Close()
+	}
+	p.asnDB = tmpDB
+	p.asnMux.Unlock()
+	return nil
+}
+
+func (p *MaxMindProvider) LookupCity(ip net.IP) (*CityRecord, error) {
+	p.cityMux.RLock()
+	defer p.cityMux.RUnlock()
+	if p.cityDB == nil {
+		return nil, errNoDatabase
+	}
+	// City lookups work on Country databases too
+	rec, err := p.cityDB.City(ip)
+	if err != nil {
+		return nil, err
+	}
+	ret := &CityRecord{
+		Country:   rec.Country.IsoCode,
+		City:      rec.City.Names["en"],
+		Latitude:  rec.Location.Latitude,
+		Longitude: rec.Location.Longitude,
+	}
+	if len(rec.Subdivisions) > 0 {
+		ret.Region = rec.Subdivisions[0].IsoCode
+	}
+	return ret, nil
+}
+
+func (p *MaxMindProvider) LookupASN(ip net.IP) (*ASNRecord, error) {
+	p.asnMux.RLock()
+	defer p.asnMux.RUnlock()
+	if p.asnDB == nil {
+		return nil, errNoDatabase
+	}
+	if p.asnDB.Metadata().DatabaseType == geoLite2ASN {
+		rec, err := p.asnDB.ASN(ip)
+		if err != nil {
+			return nil, err
+		}
+		return &ASNRecord{
+			Number:       rec.AutonomousSystemNumber,
+			Organization: rec.AutonomousSystemOrganization,
+		}, nil
+	}
+	rec, err := p.asnDB.ISP(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &ASNRecord{
+		Number:       rec.AutonomousSystemNumber,
+		Organization: rec.AutonomousSystemOrganization,
+		ISP:          rec.ISP,
+		OrgName:      rec.Organization,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package extra_fields
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/valyala/fastjson"
+)
+
+// Policies for extra fields already present in events
+const (
+	ConflictKeep      = "keep"
+	ConflictOverwrite = "overwrite"
+	// ConflictPrefix keeps the event property and adds the extra field with the conflict prefix
+	ConflictPrefix = "prefix"
+
+	DefaultConflictPrefix       = "extra_"
+	DefaultInvalidMessagesTopic = "malformed"
+)
+
+var ErrNotObject = errors.New("not a JSON object")
+
+// Merger adds extra fields to events
+type Merger struct {
+	conflicts    string
+	prefix       string
+	invalidTopic string
+}
+
+// DefaultMerger overwrites conflicting event properties and routes events which are not JSON objects to malformed
+var DefaultMerger = &Merger{
+	conflicts:    ConflictOverwrite,
+	prefix:       DefaultConflictPrefix,
+	invalidTopic: DefaultInvalidMessagesTopic,
+}
+
+func NewMerger(config Config) (*Merger, error) {
+	m := &Merger{
+		conflicts:    config.Conflicts,
+		prefix:       config.ConflictPrefix,
+		invalidTopic: config.InvalidMessagesTopic,
+	}
+	switch m.conflicts {
+	case "":
+		m.conflicts = ConflictOverwrite
+	case ConflictKeep, ConflictOverwrite, ConflictPrefix:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %s", config.Conflicts)
+	}
+	if m.prefix == "" {
+		m.prefix = DefaultConflictPrefix
+	}
+	if m.invalidTopic == "" {
+		m.invalidTopic = DefaultInvalidMessagesTopic
+	}
+	return m, nil
+}
+
+// Merge returns the line with the fields of extra added, both must be JSON objects
+func (m *Merger) Merge(line []byte, extra []byte) ([]byte, error) {
+	var lineParser, extraParser fastjson.Parser
+	root, err := parseObject(&lineParser, line)
+	if err != nil {
+		return nil, err
+	}
+	extraRoot, err := parseObject(&extraParser, extra)
+	if err != nil {
+		return nil, fmt.Errorf("extra fields: %w", err)
+	}
+	m.mergeObject(root.GetObject(), newEntries(extraRoot.GetObject()), nil)
+	return root.MarshalTo(nil), nil
+}
+
+// entry is an extra field ready to be merged into events, its key is converted once
+type entry struct {
+	key   string
+	value *fastjson.Value
+	// entries of an object value
+	entries []entry
+}
+
+func newEntries(o *fastjson.Object) []entry {
+	entries := make([]entry, 0, o.Len())
+	o.Visit(func(key []byte, v *fastjson.Value) {
+		e := entry{key: string(key), value: v}
+		if v.Type() == fastjson.TypeObject {
+			e.entries = newEntries(v.GetObject())
+		}
+		entries = append(entries, e)
+	})
+	return entries
+}
+
+// mergeObject adds the entries of extra to o, values are shared.
+// Objects present in both are merged, the conflict policy applies to their leaves.
+// Objects added to o are copied to the arena if set, so merging more fields into o
+// later doesn't modify extra.
+func (m *Merger) mergeObject(o *fastjson.Object, extra []entry, arena *fastjson.Arena) {
+	for _, e := range extra {
+		k := e.key
+		object := e.value.Type() == fastjson.TypeObject
+		// Set finds overwritten leaves itself
+		if !object && m.conflicts == ConflictOverwrite {
+			o.Set(k, e.value)
+			continue
+		}
+		if existing := o.Get(k); existing != nil {
+			if object && existing.Type() == fastjson.TypeObject {
+				m.mergeObject(existing.GetObject(), e.entries, arena)
+				continue
+			}
+			if m.conflicts == ConflictKeep {
+				continue
+			}
+			if m.conflicts == ConflictPrefix {
+				k = m.prefix + k
+			}
+		}
+		if object && arena != nil {
+			copied := arena.NewObject()
+			m.mergeObject(copied.GetObject(), e.entries, arena)
+			o.Set(k, copied)
+			continue
+		}
+		o.Set(k, e.value)
+	}
+}
+
+func parseObject(p *fastjson.Parser, data []byte) (*fastjson.Value, error) {
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if v.Type() != fastjson.TypeObject {
+		return nil, ErrNotObject
+	}
+	return v, nil
+}
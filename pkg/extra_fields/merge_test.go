@@ -0,0 +1,130 @@
+package extra_fields
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	lor "github.com/anchorfree/data-go/pkg/line_offset_reader"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_Merge(t *testing.T) {
+	line := []byte(`{"event":"test","from_country":"XX","extra_from_country":"YY","n":1.50}`)
+	extra := []byte(`{"from_country":"UA","host":"example.com"}`)
+	tests := map[string]string{
+		ConflictKeep:      `{"event":"test","from_country":"XX","extra_from_country":"YY","n":1.50,"host":"example.com"}`,
+		ConflictOverwrite: `{"event":"test","from_country":"UA","extra_from_country":"YY","n":1.50,"host":"example.com"}`,
+		ConflictPrefix:    `{"event":"test","from_country":"XX","extra_from_country":"UA","n":1.50,"host":"example.com"}`,
+	}
+	for conflicts, expected := range tests {
+		merger, err := NewMerger(Config{Conflicts: conflicts})
+		require.NoError(t, err)
+		merged, err := merger.Merge(line, extra)
+		require.NoError(t, err, conflicts)
+		assert.Equal(t, expected, string(merged), conflicts)
+	}
+
+	merged, err := DefaultMerger.Merge([]byte(`{}`), extra)
+	require.NoError(t, err)
+	assert.Equal(t, `{"from_country":"UA","host":"example.com"}`, string(merged))
+
+	for _, invalid := range []string{``, `[1,2]`, `"str"`, `{"a":`, `{} {}`} {
+		_, err := DefaultMerger.Merge([]byte(invalid), extra)
+		assert.Error(t, err, invalid)
+	}
+	_, err = DefaultMerger.Merge(line, []byte(`[]`))
+	assert.Error(t, err)
+
+	_, err = NewMerger(Config{Conflicts: "merge"})
+	assert.Error(t, err)
+}
+
+func TestMerger_MergeNested(t *testing.T) {
+	line := []byte(`{"event":"test","geo":{"country":"XX","source":"client"},"user":"u1"}`)
+	extra := []byte(`{"geo":{"country":"UA","city":"Kyiv"},"user":{"id":1}}`)
+	tests := map[string]string{
+		ConflictKeep:      `{"event":"test","geo":{"country":"XX","source":"client","city":"Kyiv"},"user":"u1"}`,
+		ConflictOverwrite: `{"event":"test","geo":{"country":"UA","source":"client","city":"Kyiv"},"user":{"id":1}}`,
+		ConflictPrefix:    `{"event":"test","geo":{"country":"XX","source":"client","extra_country":"UA","city":"Kyiv"},"user":"u1","extra_user":{"id":1}}`,
+	}
+	for conflicts, expected := range tests {
+		merger, err := NewMerger(Config{Conflicts: conflicts})
+		require.NoError(t, err)
+		merged, err := merger.Merge(line, extra)
+		require.NoError(t, err, conflicts)
+		assert.Equal(t, expected, string(merged), conflicts)
+	}
+}
+
+func TestEventIterator_MergesNestedProfile(t *testing.T) {
+	merger, err := NewMerger(Config{Conflicts: ConflictKeep})
+	require.NoError(t, err)
+	lines := []byte("{\"event\":\"test\",\"geo\":{\"host\":\"spoofed\",\"lang\":\"de\"}}\n")
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Host", "example.com")
+	efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, NewEnricher(nil)).
+		WithProfiles(mustProfiles(t, ProfileConfig{Fields: []string{"host", "cloudfront"}, Nest: "geo"})).
+		WithMerger(merger)
+
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"event":"test","geo":{"host":"spoofed","lang":"de","cloudfront":0}}`, string(efi.At().Message))
+	assert.False(t, efi.Next())
+}
+
+func TestEventIterator_MergesFuncObjects(t *testing.T) {
+	var keys []string
+	lines := []byte("{\"event\":\"test\"}\n{\"event\":\"test\",\"meta\":{\"g\":2}}\n{\"event\":\"test\"}\n")
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Host", "example.com")
+	efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, NewEnricher(nil)).
+		WithProfiles(mustProfiles(t, ProfileConfig{Fields: []string{"host"}, Nest: "meta"})).
+		WithFunc("meta", func() interface{} {
+			keys = append(keys, fmt.Sprintf("f%d", len(keys)))
+			return map[string]interface{}{keys[len(keys)-1]: 1}
+		})
+
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"event":"test","meta":{"host":"example.com","f0":1}}`, string(efi.At().Message))
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"event":"test","meta":{"g":2,"host":"example.com","f1":1}}`, string(efi.At().Message))
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"event":"test","meta":{"host":"example.com","f2":1}}`, string(efi.At().Message))
+	assert.False(t, efi.Next())
+	assert.NoError(t, efi.Err())
+}
+
+func TestEventIterator_RoutesNonObjects(t *testing.T) {
+	merger, err := NewMerger(Config{Conflicts: ConflictKeep, InvalidMessagesTopic: "invalid"})
+	require.NoError(t, err)
+	lines := []byte("{\"event\":\"test\",\"host\":\"spoofed\"}\n[1,2]\n{}\n \n")
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Host", "example.com")
+	efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, NewEnricher(nil)).
+		WithProfiles(mustProfiles(t, ProfileConfig{Fields: []string{"host"}})).
+		WithMerger(merger)
+
+	require.True(t, efi.Next())
+	assert.Equal(t, "test", efi.At().Topic)
+	assert.Equal(t, `{"event":"test","host":"spoofed"}`, string(efi.At().Message))
+	require.True(t, efi.Next())
+	assert.Equal(t, "invalid", efi.At().Topic)
+	assert.Equal(t, "test\t[1,2]", string(efi.At().Message))
+	require.True(t, efi.Next())
+	assert.Equal(t, "test", efi.At().Topic)
+	assert.Equal(t, `{"host":"example.com"}`, string(efi.At().Message))
+	// empty events are not rerouted
+	require.True(t, efi.Next())
+	assert.Equal(t, "test", efi.At().Topic)
+	assert.Equal(t, `{"host":"example.com"}`, string(efi.At().Message))
+	assert.False(t, efi.Next())
+}
+
+func mustProfiles(t *testing.T, config ProfileConfig) *Profiles {
+	profiles, err := NewProfiles(Config{Default: config})
+	require.NoError(t, err)
+	return profiles
+}
@@ -0,0 +1,175 @@
+package extra_fields
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldKeys are the JSON keys of ExtraFields in declaration order
+var fieldKeys = func() []string {
+	var keys []string
+	t := reflect.TypeOf(ExtraFields{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		keys = append(keys, strings.Split(tag, ",")[0])
+	}
+	return keys
+}()
+
+// field is an extra field added to events and the path of its output key
+type field struct {
+	key  string
+	path []string
+}
+
+// Profile selects the extra fields added to events of a topic and their keys
+type Profile struct {
+	// nil adds all fields with their own keys
+	fields []field
+}
+
+// DefaultProfile adds all fields at the top level
+var DefaultProfile = &Profile{}
+
+type Profiles struct {
+	defaultProfile *Profile
+	topics         map[string]*Profile
+}
+
+func NewProfiles(config Config) (*Profiles, error) {
+	defaultProfile, err := NewProfile(config.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default profile: %w", err)
+	}
+	p := &Profiles{
+		defaultProfile: defaultProfile,
+		topics:         make(map[string]*Profile, len(config.Topics)),
+	}
+	for topic, profileConfig := range config.Topics {
+		profile, err := NewProfile(profileConfig)
+		if err != nil {
+			return nil, fmt.Errorf("profile for topic %s: %w", topic, err)
+		}
+		p.topics[topic] = profile
+	}
+	return p, nil
+}
+
+// Get returns the profile of the topic, the default one if the topic has none
+func (p *Profiles) Get(topic string) *Profile {
+	if profile, found := p.topics[topic]; found {
+		return profile
+	}
+	return p.defaultProfile
+}
+
+func NewProfile(config ProfileConfig) (*Profile, error) {
+	if len(config.Fields) == 0 && len(config.Names) == 0 && config.Nest == "" {
+		return DefaultProfile, nil
+	}
+	keys := config.Fields
+	if len(keys) == 0 {
+		keys = fieldKeys
+	}
+	for key := range config.Names {
+		if !isFieldKey(key) {
+			return nil, fmt.Errorf("unknown field %s", key)
+		}
+	}
+	profile := &Profile{fields: make([]field, 0, len(keys))}
+	for _, key := range keys {
+		if !isFieldKey(key) {
+			return nil, fmt.Errorf("unknown field %s", key)
+		}
+		name, found := config.Names[key]
+		if !found && config.Nest != "" {
+			name = config.Nest + "." + key
+		} else if !found {
+			name = key
+		}
+		path := strings.Split(name, ".")
+		for _, part := range path {
+			if part == "" {
+				return nil, fmt.Errorf("field %s: invalid name %q", key, name)
+			}
+		}
+		for _, other := range profile.fields {
+			if isPrefix(other.path, path) || isPrefix(path, other.path) {
+				return nil, fmt.Errorf("fields %s and %s: conflicting names", other.key, key)
+			}
+		}
+		profile.fields = append(profile.fields, field{key: key, path: path})
+	}
+	return profile, nil
+}
+
+// render serializes the extra fields as a JSON object
+func (p *Profile) render(fields *ExtraFields) ([]byte, error) {
+	if p.fields == nil {
+		return json.Marshal(fields)
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(p.fields))
+	for _, f := range p.fields {
+		value, found := values[f.key]
+		if !found {
+			// omitted empty field
+			continue
+		}
+		object := out
+		for _, part := range f.path[:len(f.path)-1] {
+			nested, found := object[part].(map[string]interface{})
+			if !found {
+				nested = make(map[string]interface{})
+				object[part] = nested
+			}
+			object = nested
+		}
+		object[f.path[len(f.path)-1]] = value
+	}
+	return json.Marshal(out)
+}
+
+// path returns where the field is added to events, fields the profile doesn't select keep their key
+func (p *Profile) path(key string) []string {
+	for _, f := range p.fields {
+		if f.key == key {
+			return f.path
+		}
+	}
+	return []string{key}
+}
+
+func isFieldKey(key string) bool {
+	for _, fieldKey := range fieldKeys {
+		if key == fieldKey {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefix reports whether path starts with prefix
+func isPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
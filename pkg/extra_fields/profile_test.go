@@ -0,0 +1,73 @@
+package extra_fields
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	lor "github.com/anchorfree/data-go/pkg/line_offset_reader"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventIterator_WithProfiles(t *testing.T) {
+	profiles, err := NewProfiles(Config{
+		Topics: map[string]ProfileConfig{
+			"flat": {
+				Fields: []string{"from_country", "host"},
+				Names:  map[string]string{"from_country": "country"},
+			},
+			"nested": {
+				Fields: []string{"from_country", "from_city", "from_asn", "cloudfront"},
+				Names:  map[string]string{"cloudfront": "cdn.cloudfront"},
+				Nest:   "geo",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Host", "example.com")
+	req.Header.Set("x_af_c_country", "UA")
+	req.Header.Set("x_af_c_city", "Kiev")
+	req.Header.Set("x_af_asn", "54500")
+
+	tests := []struct {
+		topic    string
+		expected string
+	}{
+		{"flat", `{"event":"test","country":"UA","host":"example.com"}`},
+		{"nested", `{"event":"test","cdn":{"cloudfront":0},"geo":{"from_asn":"54500","from_city":"Kiev","from_country":"UA"}}`},
+	}
+	for _, test := range tests {
+		iter := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader([]byte(`{"event":"test"}`)), test.topic), req, NewEnricher(nil)).
+			WithProfiles(profiles)
+		require.True(t, iter.Next())
+		assert.JSONEq(t, test.expected, string(iter.At().Message), test.topic)
+	}
+
+	// topics without a profile get all fields
+	iter := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader([]byte(`{"event":"test"}`)), "other"), req, NewEnricher(nil)).
+		WithProfiles(profiles)
+	require.True(t, iter.Next())
+	var rec map[string]interface{}
+	require.NoError(t, json.Unmarshal(iter.At().Message, &rec))
+	assert.Equal(t, "UA", rec["from_country"])
+	assert.Equal(t, "example.com", rec["host"])
+	assert.Equal(t, float64(0), rec["cloudfront"])
+}
+
+func TestNewProfile_Invalid(t *testing.T) {
+	for name, config := range map[string]ProfileConfig{
+		"unknown field":   {Fields: []string{"from_galaxy"}},
+		"unknown name":    {Names: map[string]string{"from_galaxy": "galaxy"}},
+		"empty name part": {Fields: []string{"host"}, Names: map[string]string{"host": "a..b"}},
+		"conflict":        {Fields: []string{"host", "from_city"}, Names: map[string]string{"host": "geo", "from_city": "geo.city"}},
+		"duplicate":       {Fields: []string{"host", "from_city"}, Names: map[string]string{"host": "city", "from_city": "city"}},
+	} {
+		_, err := NewProfile(config)
+		assert.Error(t, err, name)
+	}
+}
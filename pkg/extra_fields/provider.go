@@ -0,0 +1,68 @@
+package extra_fields
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	errNoDatabase = errors.New("geoip database is not loaded")
+	errNotFound   = errors.New("address not found")
+)
+
+// CityRecord is the location of an address
+type CityRecord struct {
+	// ISO 3166-1 country code
+	Country string
+	City    string
+	// ISO 3166-2 code of the first subdivision
+	Region    string
+	Latitude  float64
+	Longitude float64
+}
+
+// ASNRecord is the network of an address, ISP and Organization are empty if the source has no ISP data
+type ASNRecord struct {
+	Number       uint
+	Organization string
+	ISP          string
+	OrgName      string
+}
+
+// Provider looks up the location and the network of addresses
+type Provider interface {
+	LookupCity(ip net.IP) (*CityRecord, error)
+	LookupASN(ip net.IP) (*ASNRecord, error)
+}
+
+// StaticProvider returns fixed records, it is meant for tests
+type StaticProvider struct {
+	// Records by address
+	Cities map[string]*CityRecord
+	ASNs   map[string]*ASNRecord
+	// Records of addresses missing in the maps, not found if nil
+	DefaultCity *CityRecord
+	DefaultASN  *ASNRecord
+}
+
+var _ Provider = (*StaticProvider)(nil)
+
+func (p *StaticProvider) LookupCity(ip net.IP) (*CityRecord, error) {
+	if rec, found := p.Cities[ip.String()]; found {
+		return rec, nil
+	}
+	if p.DefaultCity != nil {
+		return p.DefaultCity, nil
+	}
+	return nil, errNotFound
+}
+
+func (p *StaticProvider) LookupASN(ip net.IP) (*ASNRecord, error) {
+	if rec, found := p.ASNs[ip.String()]; found {
+		return rec, nil
+	}
+	if p.DefaultASN != nil {
+		return p.DefaultASN, nil
+	}
+	return nil, errNotFound
+}
@@ -0,0 +1,75 @@
+package extra_fields
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnricher_StaticProvider(t *testing.T) {
+	provider := &StaticProvider{
+		Cities: map[string]*CityRecord{
+			"81.2.69.160": {Country: "GB", City: "London", Region: "ENG", Latitude: 51.5142, Longitude: -0.0931},
+		},
+		DefaultASN: &ASNRecord{Number: 1221, Organization: "Telstra Pty Ltd", ISP: "Telstra Internet", OrgName: "Telstra Internet"},
+	}
+	enricher := NewEnricher(nil).WithProvider(provider)
+
+	fields := enricher.ExtraFields(&http.Request{RemoteAddr: "81.2.69.160:443", Header: http.Header{}})
+	assert.Equal(t, "GB", fields.Country)
+	assert.Equal(t, "London", fields.City)
+	assert.Equal(t, "ENG", fields.Region)
+	assert.Equal(t, 51.5142, fields.Latitude)
+	assert.Equal(t, -0.0931, fields.Longitude)
+	assert.Equal(t, "1221", fields.FromASN)
+	assert.Equal(t, "Telstra Pty Ltd", fields.FromASDesc)
+	assert.Equal(t, "Telstra Internet", fields.FromISP)
+	assert.Equal(t, "Telstra Internet", fields.FromOrgName)
+
+	fields = enricher.ExtraFields(&http.Request{RemoteAddr: "1.2.3.4:443", Header: http.Header{}})
+	assert.Empty(t, fields.Country)
+	assert.Equal(t, "1221", fields.FromASN)
+}
+
+func TestTableProvider(t *testing.T) {
+	table := `network,country,city,latitude,longitude,asn,as_organization
+10.0.0.0/8,US,,,,AS64500,Example
+10.1.0.0/16,US,Boston,42.36,-71.06,64501,Example Boston
+2001:db8::1,DE,Berlin,,,,`
+	provider, err := NewTableProvider(strings.NewReader(table))
+	require.NoError(t, err)
+
+	city, err := provider.LookupCity(net.ParseIP("10.1.2.3"))
+	require.NoError(t, err)
+	assert.Equal(t, &CityRecord{Country: "US", City: "Boston", Latitude: 42.36, Longitude: -71.06}, city)
+	asn, err := provider.LookupASN(net.ParseIP("10.1.2.3"))
+	require.NoError(t, err)
+	assert.Equal(t, &ASNRecord{Number: 64501, Organization: "Example Boston"}, asn)
+
+	asn, err = provider.LookupASN(net.ParseIP("10.2.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint(64500), asn.Number)
+
+	city, err = provider.LookupCity(net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "Berlin", city.City)
+
+	_, err = provider.LookupCity(net.ParseIP("192.0.2.1"))
+	assert.Error(t, err)
+}
+
+func TestTableProvider_Invalid(t *testing.T) {
+	for _, table := range []string{
+		"country,city\nUS,Boston",
+		"network,asn\n10.0.0.0/8,ASX",
+		"network,latitude\n10.0.0.0/8,north",
+		"network\n10.0.0.0/33",
+	} {
+		_, err := NewTableProvider(strings.NewReader(table))
+		assert.Error(t, err, table)
+	}
+}
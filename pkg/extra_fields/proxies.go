@@ -0,0 +1,203 @@
+package extra_fields
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anchorfree/data-go/pkg/utils"
+)
+
+// Sources of the client address recorded as from_ip_source
+const (
+	IPSourceRemoteAddr    = "remote_addr"
+	IPSourceXRealIP       = "x_real_ip"
+	IPSourceXForwardedFor = "x_forwarded_for"
+	IPSourceForwarded     = "forwarded"
+)
+
+const cloudFrontService = "CLOUDFRONT"
+
+type TrustedProxyConfig struct {
+	// Networks of proxies in front of the service, single addresses are allowed
+	CIDRs []string `yaml:"cidrs"`
+	// Number of proxies in front of the service trusted whatever their addresses
+	Hops int `yaml:"hops"`
+	// AWS ip-ranges.json, the CLOUDFRONT prefixes are trusted
+	CloudFrontRangesFile string `yaml:"cloudfront_ranges_file"`
+	// Use the RFC 7239 Forwarded header over X-Forwarded-For when both are set
+	Forwarded bool `yaml:"forwarded"`
+}
+
+// TrustedProxies resolves the client address of requests passed through proxies.
+// Addresses are taken from the proxy chain right to left, the first one not
+// added by a trusted proxy is the client.
+type TrustedProxies struct {
+	nets      []*net.IPNet
+	hops      int
+	forwarded bool
+}
+
+func NewTrustedProxies(config *TrustedProxyConfig) (*TrustedProxies, error) {
+	p := &TrustedProxies{hops: config.Hops, forwarded: config.Forwarded}
+	for _, cidr := range config.CIDRs {
+		ipNet, err := parseNetwork(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	if config.CloudFrontRangesFile != "" {
+		f, err := os.Open(filepath.Clean(config.CloudFrontRangesFile))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		nets, err := LoadCloudFrontRanges(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CloudFront ranges: %w", err)
+		}
+		p.nets = append(p.nets, nets...)
+	}
+	return p, nil
+}
+
+// LoadCloudFrontRanges reads the CloudFront networks from AWS ip-ranges.json
+func LoadCloudFrontRanges(r io.Reader) ([]*net.IPNet, error) {
+	var ranges struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&ranges); err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, prefix := range ranges.Prefixes {
+		if prefix.Service == cloudFrontService {
+			prefixes = append(prefixes, prefix.IPPrefix)
+		}
+	}
+	for _, prefix := range ranges.IPv6Prefixes {
+		if prefix.Service == cloudFrontService {
+			prefixes = append(prefixes, prefix.IPv6Prefix)
+		}
+	}
+	nets := make([]*net.IPNet, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the client address of the request and the source it was taken from.
+// An address that can't be parsed ends the chain, the address of the proxy which added it is returned.
+func (p *TrustedProxies) ClientIP(req *http.Request) (net.IP, string) {
+	chain, chainSource := p.chain(req)
+	ip, source := remoteIP(req), IPSourceRemoteAddr
+	for hop := 0; len(chain) > 0 && p.trusted(ip, hop); hop++ {
+		next := chain[len(chain)-1]
+		chain = chain[:len(chain)-1]
+		if next == nil {
+			break
+		}
+		ip, source = next, chainSource
+	}
+	return ip, source
+}
+
+func (p *TrustedProxies) trusted(ip net.IP, hop int) bool {
+	if ip == nil {
+		return false
+	}
+	if hop < p.hops {
+		return true
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// chain returns addresses the proxies reported, the client first
+func (p *TrustedProxies) chain(req *http.Request) ([]net.IP, string) {
+	if p.forwarded {
+		if elements := utils.ParseList(req.Header, "Forwarded"); len(elements) > 0 {
+			return parseForwarded(elements), IPSourceForwarded
+		}
+	}
+	if addresses := utils.ParseList(req.Header, "X-Forwarded-For"); len(addresses) > 0 {
+		chain := make([]net.IP, len(addresses))
+		for i, address := range addresses {
+			chain[i] = parseNode(address)
+		}
+		return chain, IPSourceXForwardedFor
+	}
+	if address := req.Header.Get("X-Real-Ip"); address != "" {
+		return []net.IP{parseNode(address)}, IPSourceXRealIP
+	}
+	return nil, ""
+}
+
+// parseForwarded returns the for= addresses of Forwarded header elements,
+// nil for elements without one, "unknown" or obfuscated identifiers
+func parseForwarded(elements []string) []net.IP {
+	chain := make([]net.IP, len(elements))
+	for i, element := range elements {
+		for _, pair := range strings.Split(element, ";") {
+			pair = strings.TrimSpace(pair)
+			if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+				chain[i] = parseNode(strings.Trim(pair[4:], `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// parseNode parses an address with an optional port, IPv6 addresses with a port are bracketed
+func parseNode(node string) net.IP {
+	node = strings.TrimSpace(node)
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+func remoteIP(req *http.Request) net.IP {
+	return parseNode(req.RemoteAddr)
+}
+
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, errors.New("invalid address")
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	return ipNet, err
+}
@@ -0,0 +1,91 @@
+package extra_fields
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies(&TrustedProxyConfig{
+		CIDRs:     []string{"10.0.0.0/8", "2001:db8::1"},
+		Forwarded: true,
+	})
+	require.NoError(t, err)
+
+	newRequest := func(remoteAddr string, headers ...string) *http.Request {
+		h := http.Header{}
+		for i := 0; i+1 < len(headers); i += 2 {
+			h.Add(headers[i], headers[i+1])
+		}
+		return &http.Request{RemoteAddr: remoteAddr, Header: h}
+	}
+
+	for _, test := range []struct {
+		name     string
+		request  *http.Request
+		expected string
+		source   string
+	}{
+		{"untrusted peer", newRequest("144.12.54.87:443", "X-Forwarded-For", "1.1.1.1"), "144.12.54.87", IPSourceRemoteAddr},
+		{"no headers", newRequest("10.0.0.1:443"), "10.0.0.1", IPSourceRemoteAddr},
+		{"right-most untrusted", newRequest("10.0.0.1:443", "X-Forwarded-For", "1.1.1.1, 144.12.54.87, 10.0.0.2"), "144.12.54.87", IPSourceXForwardedFor},
+		{"all trusted", newRequest("10.0.0.1:443", "X-Forwarded-For", "10.0.0.3, 10.0.0.2"), "10.0.0.3", IPSourceXForwardedFor},
+		{"several headers", newRequest("10.0.0.1:443", "X-Forwarded-For", "1.1.1.1", "X-Forwarded-For", "10.0.0.2"), "1.1.1.1", IPSourceXForwardedFor},
+		{"garbage stops the chain", newRequest("10.0.0.1:443", "X-Forwarded-For", "1.1.1.1, garbage"), "10.0.0.1", IPSourceRemoteAddr},
+		{"x-real-ip", newRequest("10.0.0.1:443", "X-Real-Ip", "1.1.1.1"), "1.1.1.1", IPSourceXRealIP},
+		{"forwarded", newRequest("[2001:db8::1]:443", "Forwarded", `for=1.1.1.1;proto=https, for="[2001:db8::2]:8080";by=10.0.0.1`, "X-Forwarded-For", "3.3.3.3"), "2001:db8::2", IPSourceForwarded},
+		{"forwarded unknown", newRequest("10.0.0.1:443", "Forwarded", "for=1.1.1.1, for=unknown"), "10.0.0.1", IPSourceRemoteAddr},
+	} {
+		ip, source := proxies.ClientIP(test.request)
+		assert.Equalf(t, test.expected, ip.String(), "test: %s", test.name)
+		assert.Equalf(t, test.source, source, "test: %s", test.name)
+	}
+}
+
+func TestTrustedProxies_Hops(t *testing.T) {
+	proxies, err := NewTrustedProxies(&TrustedProxyConfig{Hops: 2})
+	require.NoError(t, err)
+	req := &http.Request{RemoteAddr: "144.12.54.87:443", Header: http.Header{}}
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2, 3.3.3.3")
+	ip, source := proxies.ClientIP(req)
+	assert.Equal(t, "2.2.2.2", ip.String())
+	assert.Equal(t, IPSourceXForwardedFor, source)
+}
+
+func TestLoadCloudFrontRanges(t *testing.T) {
+	ranges := `{
+  "prefixes": [
+    {"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT"},
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT"}
+  ]
+}`
+	nets, err := LoadCloudFrontRanges(strings.NewReader(ranges))
+	require.NoError(t, err)
+	require.Len(t, nets, 2)
+	assert.Equal(t, "13.32.0.0/15", nets[0].String())
+	assert.Equal(t, "2600:9000::/28", nets[1].String())
+}
+
+func TestEnricher_TrustedProxies(t *testing.T) {
+	proxies, err := NewTrustedProxies(&TrustedProxyConfig{CIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	req := &http.Request{RemoteAddr: "10.0.0.1:443", Header: http.Header{}}
+	req.Header.Set("X-Forwarded-For", "144.12.54.87, 1.1.1.1")
+
+	assert.Equal(t, IPSourceXForwardedFor, NewEnricher(nil).WithTrustedProxies(proxies).ExtraFields(req).IPSource)
+	// the source isn't reported without trusted proxies
+	req = &http.Request{RemoteAddr: "1.1.1.1", Header: http.Header{"X-Forwarded-For": {"10.0.0.1"}}}
+	fields := NewEnricher(nil).ExtraFields(req)
+	assert.Equal(t, "", fields.IPSource)
+	raw, err := json.Marshal(fields)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "from_ip_source")
+}
@@ -0,0 +1,168 @@
+package extra_fields
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yl2chen/cidranger"
+)
+
+// Columns of the table provider CSV, the header row selects and orders them, network is required
+const (
+	columnNetwork      = "network"
+	columnCountry      = "country"
+	columnRegion       = "region"
+	columnCity         = "city"
+	columnLatitude     = "latitude"
+	columnLongitude    = "longitude"
+	columnASN          = "asn"
+	columnOrganization = "as_organization"
+	columnISP          = "isp"
+	columnOrgName      = "organization"
+)
+
+// TableProvider looks up addresses in an in-memory table of networks,
+// the most specific network containing the address wins
+type TableProvider struct {
+	ranger cidranger.Ranger
+}
+
+var _ Provider = (*TableProvider)(nil)
+
+type tableEntry struct {
+	ipNet net.IPNet
+	city  *CityRecord
+	asn   *ASNRecord
+}
+
+func (e *tableEntry) Network() net.IPNet {
+	return e.ipNet
+}
+
+// LoadTableProvider reads the CSV table from the file
+func LoadTableProvider(file string) (*TableProvider, error) {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewTableProvider(f)
+}
+
+// NewTableProvider reads the CSV table with a header row
+func NewTableProvider(r io.Reader) (*TableProvider, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read table header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, found := columns[columnNetwork]; !found {
+		return nil, fmt.Errorf("table has no %s column", columnNetwork)
+	}
+
+	p := &TableProvider{ranger: cidranger.NewPCTrieRanger()}
+	for record := 1; ; record++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, found := columns[column]; found && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		entry, err := newTableEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("table record %d: %w", record, err)
+		}
+		if err := p.ranger.Insert(entry); err != nil {
+			return nil, fmt.Errorf("table record %d: %w", record, err)
+		}
+	}
+}
+
+func newTableEntry(value func(column string) string) (*tableEntry, error) {
+	network := value(columnNetwork)
+	if !strings.Contains(network, "/") {
+		if strings.Contains(network, ":") {
+			network += "/128"
+		} else {
+			network += "/32"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, err
+	}
+	entry := &tableEntry{
+		ipNet: *ipNet,
+		city: &CityRecord{
+			Country: value(columnCountry),
+			Region:  value(columnRegion),
+			City:    value(columnCity),
+		},
+		asn: &ASNRecord{
+			Organization: value(columnOrganization),
+			ISP:          value(columnISP),
+			OrgName:      value(columnOrgName),
+		},
+	}
+	for column, dest := range map[string]*float64{columnLatitude: &entry.city.Latitude, columnLongitude: &entry.city.Longitude} {
+		if s := value(column); s != "" {
+			if *dest, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", column, err)
+			}
+		}
+	}
+	if s := strings.TrimPrefix(strings.ToUpper(value(columnASN)), "AS"); s != "" {
+		number, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", columnASN, err)
+		}
+		entry.asn.Number = uint(number)
+	}
+	return entry, nil
+}
+
+func (p *TableProvider) lookup(ip net.IP) (*tableEntry, error) {
+	entries, err := p.ranger.ContainingNetworks(ip)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errNotFound
+	}
+	// the ranger returns the least specific network first
+	return entries[len(entries)-1].(*tableEntry), nil
+}
+
+func (p *TableProvider) LookupCity(ip net.IP) (*CityRecord, error) {
+	entry, err := p.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	return entry.city, nil
+}
+
+func (p *TableProvider) LookupASN(ip net.IP) (*ASNRecord, error) {
+	entry, err := p.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	return entry.asn, nil
+}
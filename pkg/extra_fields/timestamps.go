@@ -0,0 +1,120 @@
+package extra_fields
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+// Actions for client timestamps implausibly far from the server one
+const (
+	ImplausibleIgnore = "ignore"
+	// ImplausibleFlag sets client_ts_implausible to true
+	ImplausibleFlag = "flag"
+	// ImplausibleClamp flags the event and moves the client timestamp to the nearest plausible one
+	ImplausibleClamp = "clamp"
+
+	DefaultRequestIDHeader = "X-Request-Id"
+
+	ClockSkewField   = "clock_skew_ms"
+	ImplausibleField = "client_ts_implausible"
+)
+
+type TimestampConfig struct {
+	// Header of the request ID, X-Request-Id by default
+	RequestIDHeader string `yaml:"request_id_header"`
+	// Dot separated path of the client timestamp in milliseconds, the clock skew is not computed if empty
+	ClientTsPath string `yaml:"client_ts_path"`
+	// Client timestamps older or newer than the server one by more are implausible, not checked if zero
+	MaxPast   time.Duration `yaml:"max_past"`
+	MaxFuture time.Duration `yaml:"max_future"`
+	// One of ignore, flag, clamp. Defaults to ignore
+	Implausible string `yaml:"implausible"`
+}
+
+// Timestamps adds the server receive time and the request ID to extra fields
+// and the clock skew of the client timestamp to events
+type Timestamps struct {
+	requestIDHeader string
+	clientTsPath    []string
+	maxPast         int64
+	maxFuture       int64
+	implausible     string
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewTimestamps(config TimestampConfig) (*Timestamps, error) {
+	ts := &Timestamps{
+		requestIDHeader: config.RequestIDHeader,
+		maxPast:         config.MaxPast.Milliseconds(),
+		maxFuture:       config.MaxFuture.Milliseconds(),
+		implausible:     config.Implausible,
+		now:             time.Now,
+	}
+	if ts.requestIDHeader == "" {
+		ts.requestIDHeader = DefaultRequestIDHeader
+	}
+	if config.ClientTsPath != "" {
+		ts.clientTsPath = strings.Split(config.ClientTsPath, ".")
+	}
+	switch ts.implausible {
+	case "":
+		ts.implausible = ImplausibleIgnore
+	case ImplausibleIgnore, ImplausibleFlag, ImplausibleClamp:
+	default:
+		return nil, fmt.Errorf("unknown implausible timestamp action %s", config.Implausible)
+	}
+	if config.MaxPast < 0 || config.MaxFuture < 0 {
+		return nil, fmt.Errorf("negative timestamp bounds")
+	}
+	return ts, nil
+}
+
+// requestFields returns the server receive time in milliseconds and the request ID
+func (ts *Timestamps) requestFields(req *http.Request) (int64, string) {
+	return ts.now().UnixNano() / int64(time.Millisecond), req.Header.Get(ts.requestIDHeader)
+}
+
+// apply adds the clock skew of the event client timestamp and checks it.
+// The server timestamp of the event is used if present, With fields may override the request one.
+func (ts *Timestamps) apply(root *fastjson.Value, serverTsPath []string, serverTs int64, arena *fastjson.Arena, merger *Merger) {
+	if len(ts.clientTsPath) == 0 {
+		return
+	}
+	v := root.Get(ts.clientTsPath...)
+	if v == nil || v.Type() != fastjson.TypeNumber {
+		return
+	}
+	clientTs := int64(v.GetFloat64())
+	if eventTs := root.Get(serverTsPath...); eventTs != nil && eventTs.Type() == fastjson.TypeNumber {
+		serverTs = int64(eventTs.GetFloat64())
+	}
+	skew := serverTs - clientTs
+	fields := [2]entry{{key: ClockSkewField, value: arena.NewNumberInt(int(skew))}}
+	o := root.GetObject()
+
+	if ts.implausible == ImplausibleIgnore {
+		merger.mergeObject(o, fields[:1], nil)
+		return
+	}
+	bound := clientTs
+	switch {
+	case ts.maxPast > 0 && skew > ts.maxPast:
+		bound = serverTs - ts.maxPast
+	case ts.maxFuture > 0 && -skew > ts.maxFuture:
+		bound = serverTs + ts.maxFuture
+	default:
+		merger.mergeObject(o, fields[:1], nil)
+		return
+	}
+	fields[1] = entry{key: ImplausibleField, value: arena.NewTrue()}
+	merger.mergeObject(o, fields[:], nil)
+	if ts.implausible == ImplausibleClamp {
+		parent := root.Get(ts.clientTsPath[:len(ts.clientTsPath)-1]...)
+		parent.Set(ts.clientTsPath[len(ts.clientTsPath)-1], arena.NewNumberInt(int(bound)))
+	}
+}
@@ -0,0 +1,102 @@
+package extra_fields
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	lor "github.com/anchorfree/data-go/pkg/line_offset_reader"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventIterator_Timestamps(t *testing.T) {
+	serverTs := int64(1521800927956)
+	lines := []byte(`{"client_ts":1521800918976}
+{"client_ts":1521700000000}
+{"client_ts":1521900000000}
+{"payload":{"client_ts":1}}
+{"client_ts":"yesterday"}
+`)
+	tests := []struct {
+		config   TimestampConfig
+		expected []string
+	}{
+		{
+			TimestampConfig{ClientTsPath: "client_ts"},
+			[]string{
+				`{"client_ts":1521800918976,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":8980}`,
+				`{"client_ts":1521700000000,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":100927956}`,
+				`{"client_ts":1521900000000,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":-99072044}`,
+				`{"payload":{"client_ts":1},"request_id":"req-1","server_ts":1521800927956}`,
+				`{"client_ts":"yesterday","request_id":"req-1","server_ts":1521800927956}`,
+			},
+		},
+		{
+			TimestampConfig{ClientTsPath: "client_ts", MaxPast: 24 * time.Hour, MaxFuture: time.Hour, Implausible: ImplausibleFlag},
+			[]string{
+				`{"client_ts":1521800918976,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":8980}`,
+				`{"client_ts":1521700000000,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":100927956,"client_ts_implausible":true}`,
+				`{"client_ts":1521900000000,"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":-99072044,"client_ts_implausible":true}`,
+				`{"payload":{"client_ts":1},"request_id":"req-1","server_ts":1521800927956}`,
+				`{"client_ts":"yesterday","request_id":"req-1","server_ts":1521800927956}`,
+			},
+		},
+		{
+			TimestampConfig{ClientTsPath: "payload.client_ts", MaxPast: 24 * time.Hour, Implausible: ImplausibleClamp},
+			[]string{
+				`{"client_ts":1521800918976,"request_id":"req-1","server_ts":1521800927956}`,
+				`{"client_ts":1521700000000,"request_id":"req-1","server_ts":1521800927956}`,
+				`{"client_ts":1521900000000,"request_id":"req-1","server_ts":1521800927956}`,
+				`{"payload":{"client_ts":1521714527956},"request_id":"req-1","server_ts":1521800927956,"clock_skew_ms":1521800927955,"client_ts_implausible":true}`,
+				`{"client_ts":"yesterday","request_id":"req-1","server_ts":1521800927956}`,
+			},
+		},
+	}
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	for _, test := range tests {
+		timestamps, err := NewTimestamps(test.config)
+		require.NoError(t, err)
+		timestamps.now = func() time.Time { return time.Unix(0, serverTs*int64(time.Millisecond)) }
+		enricher := NewEnricher(nil).WithTimestamps(timestamps)
+		efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, enricher).
+			WithProfiles(mustProfiles(t, ProfileConfig{Fields: []string{"server_ts", "request_id"}}))
+		for _, expected := range test.expected {
+			require.True(t, efi.Next())
+			assert.Equal(t, expected, string(efi.At().Message))
+		}
+		assert.False(t, efi.Next())
+	}
+}
+
+func TestEventIterator_TimestampsMerged(t *testing.T) {
+	lines := []byte(`{"client_ts":1521800918976}
+{"client_ts":1521700000000,"clock_skew_ms":5}
+`)
+	timestamps, err := NewTimestamps(TimestampConfig{ClientTsPath: "client_ts", MaxPast: 24 * time.Hour, Implausible: ImplausibleFlag})
+	require.NoError(t, err)
+	timestamps.now = func() time.Time { return time.Unix(1521800927, 956*int64(time.Millisecond)) }
+	merger, err := NewMerger(Config{Conflicts: ConflictPrefix})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/", nil)
+	efi := NewIteratorWithEnricher(lor.NewIterator(bytes.NewReader(lines), "test"), req, NewEnricher(nil).WithTimestamps(timestamps)).
+		WithProfiles(mustProfiles(t, ProfileConfig{Fields: []string{"server_ts"}, Names: map[string]string{"server_ts": "ts.server"}})).
+		With(map[string]interface{}{"ts": map[string]interface{}{"server": 1521800928976}}).
+		WithMerger(merger)
+
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"client_ts":1521800918976,"ts":{"server":1521800928976},"clock_skew_ms":10000}`, string(efi.At().Message))
+	require.True(t, efi.Next())
+	assert.Equal(t, `{"client_ts":1521700000000,"clock_skew_ms":5,"ts":{"server":1521800928976},"extra_clock_skew_ms":100928976,"client_ts_implausible":true}`, string(efi.At().Message))
+	assert.False(t, efi.Next())
+}
+
+func TestNewTimestamps_Invalid(t *testing.T) {
+	_, err := NewTimestamps(TimestampConfig{Implausible: "drop"})
+	assert.Error(t, err)
+	_, err = NewTimestamps(TimestampConfig{MaxPast: -time.Second})
+	assert.Error(t, err)
+}
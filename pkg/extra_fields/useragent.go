@@ -0,0 +1,223 @@
+package extra_fields
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/anchorfree/data-go/pkg/file_watcher"
+	"github.com/anchorfree/data-go/pkg/logger"
+	"github.com/anchorfree/data-go/pkg/utils"
+)
+
+// defaultUserAgentRules is the embedded regex database
+var (
+	//go:embed useragent.yaml
+	defaultUserAgentRules []byte
+)
+
+// clientHintsBrands maps Sec-CH-UA brands to the browser names of the regex database
+var clientHintsBrands = map[string]string{
+	"Google Chrome":  "Chrome",
+	"Microsoft Edge": "Edge",
+}
+
+// UserAgent is the client software of a request
+type UserAgent struct {
+	OS          string
+	OSVersion   string
+	DeviceClass string
+	Browser     string
+	App         string
+	AppVersion  string
+}
+
+type UserAgentRule struct {
+	Regex string `yaml:"regex"`
+	// $1, $2... refer to groups of the regex, an empty name ends the search without a result
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// UserAgentRules is the regex database, the first matching rule of a section wins.
+// Names of device rules are device classes, e.g. mobile, tablet, desktop.
+type UserAgentRules struct {
+	OS       []UserAgentRule `yaml:"os"`
+	Browsers []UserAgentRule `yaml:"browsers"`
+	Devices  []UserAgentRule `yaml:"devices"`
+	Apps     []UserAgentRule `yaml:"apps"`
+}
+
+type uaRule struct {
+	re      *regexp.Regexp
+	name    string
+	version string
+}
+
+type uaRules struct {
+	os       []uaRule
+	browsers []uaRule
+	devices  []uaRule
+	apps     []uaRule
+}
+
+// UserAgentParser parses User-Agent and client hints headers
+type UserAgentParser struct {
+	mux     sync.RWMutex
+	rules   *uaRules
+	watcher *file_watcher.T
+}
+
+// NewUserAgentParser creates a parser using the embedded regex database
+func NewUserAgentParser() *UserAgentParser {
+	p := &UserAgentParser{}
+	if err := p.FromBytes(defaultUserAgentRules); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// FromFile loads the regex database from the YAML file and reloads it on change
+func (p *UserAgentParser) FromFile(file string) error {
+	if err := p.loadFile(file); err != nil {
+		return err
+	}
+	watcher, err := file_watcher.New(file, func(string) {
+		if err := p.loadFile(file); err != nil {
+			logger.Get().Errorf("Could not reload user agent database from %s: %v", file, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	p.watcher = watcher
+	return nil
+}
+
+// Close stops watching the database file
+func (p *UserAgentParser) Close() error {
+	if p.watcher == nil {
+		return nil
+	}
+	return p.watcher.Close()
+}
+
+func (p *UserAgentParser) loadFile(file string) error {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	return p.FromBytes(data)
+}
+
+// FromBytes loads the regex database in YAML
+func (p *UserAgentParser) FromBytes(data []byte) error {
+	config := UserAgentRules{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	rules := &uaRules{}
+	for _, section := range []struct {
+		name   string
+		config []UserAgentRule
+		rules  *[]uaRule
+	}{
+		{"os", config.OS, &rules.os},
+		{"browsers", config.Browsers, &rules.browsers},
+		{"devices", config.Devices, &rules.devices},
+		{"apps", config.Apps, &rules.apps},
+	} {
+		for i, rule := range section.config {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("%s rule %d: %w", section.name, i, err)
+			}
+			*section.rules = append(*section.rules, uaRule{re: re, name: rule.Name, version: rule.Version})
+		}
+	}
+	p.mux.Lock()
+	p.rules = rules
+	p.mux.Unlock()
+	return nil
+}
+
+// Parse returns the client software of the request, client hints win over the User-Agent
+func (p *UserAgentParser) Parse(req *http.Request) UserAgent {
+	p.mux.RLock()
+	rules := p.rules
+	p.mux.RUnlock()
+
+	var ua UserAgent
+	if userAgent := req.Header.Get("User-Agent"); userAgent != "" {
+		ua.OS, ua.OSVersion = match(rules.os, userAgent)
+		ua.Browser, _ = match(rules.browsers, userAgent)
+		ua.DeviceClass, _ = match(rules.devices, userAgent)
+		ua.App, ua.AppVersion = match(rules.apps, userAgent)
+	}
+
+	if platform := unquote(req.Header.Get("Sec-CH-UA-Platform")); platform != "" {
+		ua.OS = platform
+		ua.OSVersion = unquote(req.Header.Get("Sec-CH-UA-Platform-Version"))
+	}
+	if req.Header.Get("Sec-CH-UA-Mobile") == "?1" {
+		ua.DeviceClass = "mobile"
+	}
+	if brand := clientHintsBrand(req.Header); brand != "" {
+		ua.Browser = brand
+	}
+	return ua
+}
+
+// match returns the name and the version of the first rule matching the value
+func match(rules []uaRule, value string) (string, string) {
+	for _, rule := range rules {
+		groups := rule.re.FindStringSubmatchIndex(value)
+		if groups == nil {
+			continue
+		}
+		name := string(rule.re.ExpandString(nil, rule.name, value, groups))
+		if name == "" {
+			return "", ""
+		}
+		return name, string(rule.re.ExpandString(nil, rule.version, value, groups))
+	}
+	return "", ""
+}
+
+// clientHintsBrand returns the browser of the Sec-CH-UA brand list, GREASE brands and
+// Chromium, which Chromium based browsers list next to their own brand, are skipped
+func clientHintsBrand(header http.Header) string {
+	var chromium bool
+	for _, item := range utils.ParseList(header, "Sec-CH-UA") {
+		brand := item
+		if i := strings.LastIndex(item, ";"); i >= 0 {
+			brand = item[:i]
+		}
+		brand = unquote(brand)
+		switch {
+		case brand == "", strings.Contains(brand, "Not") && strings.Contains(brand, "Brand"):
+		case brand == "Chromium":
+			chromium = true
+		default:
+			if name, found := clientHintsBrands[brand]; found {
+				return name
+			}
+			return brand
+		}
+	}
+	if chromium {
+		return "Chromium"
+	}
+	return ""
+}
+
+func unquote(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"`)
+}
@@ -0,0 +1,104 @@
+package extra_fields
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAgentParser_Parse(t *testing.T) {
+	parser := NewUserAgentParser()
+	tests := []struct {
+		userAgent string
+		expected  UserAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46",
+			UserAgent{OS: "Windows", OSVersion: "10", DeviceClass: "desktop", Browser: "Edge"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			UserAgent{OS: "iOS", OSVersion: "16.6", DeviceClass: "mobile", Browser: "Safari"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 13; SM-S901B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Mobile Safari/537.36",
+			UserAgent{OS: "Android", OSVersion: "13", DeviceClass: "mobile", Browser: "Chrome"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 12; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36",
+			UserAgent{OS: "Android", OSVersion: "12", DeviceClass: "tablet", Browser: "Chrome"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7; rv:109.0) Gecko/20100101 Firefox/118.0",
+			UserAgent{OS: "macOS", OSVersion: "10.15", DeviceClass: "desktop", Browser: "Firefox"},
+		},
+		{
+			"HotspotShield/8.12.1 (iPhone; iOS 17.0.3; Scale/3.00)",
+			UserAgent{OS: "iOS", OSVersion: "17.0.3", DeviceClass: "mobile", App: "HotspotShield", AppVersion: "8.12.1"},
+		},
+		{
+			"Dalvik/2.1.0 (Linux; U; Android 11; Pixel 5 Build/RQ3A.210805.001.A1)",
+			UserAgent{OS: "Android", OSVersion: "11"},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{DeviceClass: "bot"},
+		},
+		{"", UserAgent{}},
+	}
+	for _, test := range tests {
+		req := &http.Request{Header: http.Header{}}
+		req.Header.Set("User-Agent", test.userAgent)
+		assert.Equal(t, test.expected, parser.Parse(req), test.userAgent)
+	}
+}
+
+func TestUserAgentParser_ClientHints(t *testing.T) {
+	req := &http.Request{Header: http.Header{}}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36")
+	req.Header.Set("Sec-CH-UA", `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`)
+	req.Header.Set("Sec-CH-UA-Platform", `"Windows"`)
+	req.Header.Set("Sec-CH-UA-Platform-Version", `"15.0.0"`)
+	req.Header.Set("Sec-CH-UA-Mobile", "?0")
+
+	assert.Equal(t, UserAgent{OS: "Windows", OSVersion: "15.0.0", DeviceClass: "desktop", Browser: "Chrome"}, NewUserAgentParser().Parse(req))
+
+	req.Header.Set("Sec-CH-UA", `" Not A;Brand";v="99", "Chromium";v="118"`)
+	req.Header.Set("Sec-CH-UA-Mobile", "?1")
+	ua := NewUserAgentParser().Parse(req)
+	assert.Equal(t, "Chromium", ua.Browser)
+	assert.Equal(t, "mobile", ua.DeviceClass)
+}
+
+func TestUserAgentParser_FromBytes(t *testing.T) {
+	parser := NewUserAgentParser()
+	require.NoError(t, parser.FromBytes([]byte(`
+os:
+  - regex: 'MyOS (\d+)'
+    name: My OS
+    version: $1
+`)))
+	req := &http.Request{Header: http.Header{}}
+	req.Header.Set("User-Agent", "Agent/1.0 (MyOS 3; Android 13)")
+	assert.Equal(t, UserAgent{OS: "My OS", OSVersion: "3"}, parser.Parse(req))
+
+	assert.Error(t, parser.FromBytes([]byte("browsers:\n  - regex: '(unclosed'\n")))
+	// the previous database is kept
+	assert.Equal(t, "My OS", parser.Parse(req).OS)
+}
+
+func TestEnricher_UserAgent(t *testing.T) {
+	req := &http.Request{RemoteAddr: "1.1.1.1", Header: http.Header{}}
+	req.Header.Set("User-Agent", "HotspotShield/8.12.1 (iPhone; iOS 17.0.3; Scale/3.00)")
+
+	fields := NewEnricher(nil).WithUserAgentParser(NewUserAgentParser()).ExtraFields(req)
+	assert.Equal(t, "iOS", fields.UAOS)
+	assert.Equal(t, "17.0.3", fields.UAOSVersion)
+	assert.Equal(t, "mobile", fields.UADeviceClass)
+	assert.Equal(t, "HotspotShield", fields.UAApp)
+	assert.Equal(t, "8.12.1", fields.UAAppVersion)
+
+	assert.Empty(t, NewEnricher(nil).ExtraFields(req).UAOS)
+}
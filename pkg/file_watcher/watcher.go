@@ -43,7 +43,13 @@ func New(file string, callback func(file string)) (*T, error) {
 		var timer *time.Timer
 		for { // nolint:gosimple
 			select {
-			case event := <-w.watcher.Events:
+			case event, ok := <-w.watcher.Events:
+				if !ok {
+					if timer != nil {
+						timer.Stop()
+					}
+					return
+				}
 				if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 					if filepath.Base(event.Name) == w.file || event.Name == w.file {
 						if timer != nil {
@@ -62,3 +68,8 @@ func New(file string, callback func(file string)) (*T, error) {
 
 	return w, nil
 }
+
+// Close stops watching the file
+func (w *T) Close() error {
+	return w.watcher.Close()
+}
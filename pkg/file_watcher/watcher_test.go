@@ -42,3 +42,28 @@ func TestGeo(t *testing.T) {
 		assert.FailNow(t, "Did not detect file change")
 	}
 }
+
+func TestClose(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-data-go-*")
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, err)
+	tmpFile, err := os.CreateTemp(tmpDir, "test-file-close-*")
+	assert.NoError(t, err)
+	defer tmpFile.Close()
+
+	c := make(chan struct{}, 1)
+	DefaultTimeoutAfterLastEvent = 100 * time.Millisecond
+	w, err := New(tmpFile.Name(), func(fn string) {
+		c <- struct{}{}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	_, err = tmpFile.Write([]byte("changed after close"))
+	assert.NoError(t, err)
+	select {
+	case <-c:
+		assert.FailNow(t, "File change detected after close")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
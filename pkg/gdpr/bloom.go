@@ -0,0 +1,64 @@
+package gdpr
+
+import (
+	"math"
+)
+
+// bloomFilter answers "definitely not present" without touching the exact set,
+// k bit positions are derived from two halves of a 64-bit FNV-1a hash
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func (f *bloomFilter) add(value []byte) {
+	h1, h2 := bloomHash(value)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(value []byte) bool {
+	h1, h2 := bloomHash(value)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bloomHash(value []byte) (uint64, uint64) {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for _, c := range value {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return h & 0xffffffff, h>>32 | 1
+}
@@ -0,0 +1,65 @@
+package gdpr
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	// Salt for the hash action
+	Salt string `yaml:"salt"`
+	// Property the pseudonymize action records the active salt ID to, gdpr_salt_id by default
+	SaltIDField string `yaml:"salt_id_field"`
+	// Addresses left as is, addresses labelled "af" by the geo set if not set
+	Exemptions *ExemptionConfig `yaml:"exemptions"`
+	// Policy for topics missing in Topics
+	Default PolicyConfig            `yaml:"default"`
+	Topics  map[string]PolicyConfig `yaml:"topics"`
+}
+
+type PolicyConfig struct {
+	// Action for addresses found outside of configured fields
+	ActionConfig `yaml:",inline"`
+	Fields       []FieldConfig `yaml:"fields"`
+	// Names of detectors to run, only "ip" if empty
+	Detectors []string `yaml:"detectors"`
+	// Overrides the global exemptions for the topic
+	Exemptions *ExemptionConfig `yaml:"exemptions"`
+}
+
+type ExemptionConfig struct {
+	// Geo labels of addresses left as is
+	Labels []string `yaml:"labels"`
+	// Networks left as is, single addresses are allowed
+	CIDRs []string `yaml:"cidrs"`
+	// Leave addresses of special-purpose ranges as is, e.g. private, CGNAT, link-local
+	SpecialPurpose bool `yaml:"special_purpose"`
+}
+
+type FieldConfig struct {
+	// Dot separated JSON path, array items share the path of the array
+	Path         string `yaml:"path"`
+	ActionConfig `yaml:",inline"`
+}
+
+type ActionConfig struct {
+	// One of keep, mask, truncate, hash, pseudonymize, drop. Defaults to mask
+	Action string `yaml:"action"`
+	// Prefix length kept by truncate, 24 and 48 by default
+	IPv4Prefix int `yaml:"ipv4_prefix"`
+	IPv6Prefix int `yaml:"ipv6_prefix"`
+}
+
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
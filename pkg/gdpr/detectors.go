@@ -0,0 +1,235 @@
+package gdpr
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Detector finds personal data in values and masks it
+type Detector interface {
+	// Find returns [start, end) offsets of personal data found in the value.
+	// The key is the name of the JSON property holding the value,
+	// it is empty when a raw message is scanned.
+	Find(key string, value []byte) [][]int
+	// Mask returns the replacement for the found data
+	Mask(match []byte) []byte
+}
+
+// IPDetector is the name of the built-in address detector,
+// addresses are rewritten by the policy actions instead of a Detector
+const IPDetector = "ip"
+
+var (
+	detectorsMux = &sync.RWMutex{}
+	detectors    = map[string]Detector{}
+)
+
+func init() {
+	RegisterDetector("email", &regexpDetector{regexp: emailRegex, mask: maskEmail})
+	RegisterDetector("phone", &regexpDetector{regexp: phoneRegex, mask: maskPhone, valid: validPhone})
+	RegisterDetector("mac", &regexpDetector{regexp: macRegex, mask: maskMAC})
+	RegisterDetector("imei", &regexpDetector{regexp: imeiRegex, mask: maskIMEI, valid: luhn})
+	RegisterDetector("adid", &regexpDetector{regexp: uuidRegex, mask: maskUUID})
+	RegisterDetector("coordinates", &coordinatesDetector{})
+}
+
+// RegisterDetector makes the detector available to policies by name
+func RegisterDetector(name string, detector Detector) {
+	detectorsMux.Lock()
+	defer detectorsMux.Unlock()
+	detectors[name] = detector
+}
+
+func getDetector(name string) (Detector, error) {
+	detectorsMux.RLock()
+	defer detectorsMux.RUnlock()
+	detector, found := detectors[name]
+	if !found {
+		return nil, fmt.Errorf("unknown detector: %s", name)
+	}
+	return detector, nil
+}
+
+// DetectorNames returns names of registered detectors
+func DetectorNames() []string {
+	detectorsMux.RLock()
+	defer detectorsMux.RUnlock()
+	names := []string{IPDetector}
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maskSpans replaces found spans with the detector mask
+func maskSpans(value []byte, spans [][]int, detector Detector) []byte {
+	if len(spans) == 0 {
+		return value
+	}
+	ret := make([]byte, 0, len(value))
+	last := 0
+	for _, span := range spans {
+		if span[0] < last {
+			continue
+		}
+		ret = append(ret, value[last:span[0]]...)
+		ret = append(ret, detector.Mask(value[span[0]:span[1]])...)
+		last = span[1]
+	}
+	return append(ret, value[last:]...)
+}
+
+type regexpDetector struct {
+	regexp *regexp.Regexp
+	mask   func([]byte) []byte
+	valid  func([]byte) bool
+}
+
+func (d *regexpDetector) Find(key string, value []byte) [][]int {
+	spans := d.regexp.FindAllIndex(value, -1)
+	if d.valid == nil {
+		return spans
+	}
+	valid := spans[:0]
+	for _, span := range spans {
+		if d.valid(value[span[0]:span[1]]) {
+			valid = append(valid, span)
+		}
+	}
+	return valid
+}
+
+func (d *regexpDetector) Mask(match []byte) []byte {
+	return d.mask(match)
+}
+
+var (
+	emailRegex = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	phoneRegex = regexp.MustCompile(`\+[1-9][0-9 ()\-]{6,20}[0-9]`)
+	macRegex   = regexp.MustCompile(`(?i)\b[0-9a-f]{2}([:\-])[0-9a-f]{2}(?:[:\-][0-9a-f]{2}){4}\b`)
+	imeiRegex  = regexp.MustCompile(`\b[0-9]{15}\b`)
+	uuidRegex  = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+)
+
+// maskEmail keeps the domain only: ***@example.com
+func maskEmail(match []byte) []byte {
+	at := bytes.LastIndexByte(match, '@')
+	return append([]byte("***"), match[at:]...)
+}
+
+func countDigits(match []byte) int {
+	digits := 0
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits
+}
+
+// E.164 numbers have up to 15 digits
+func validPhone(match []byte) bool {
+	digits := countDigits(match)
+	return digits >= 8 && digits <= 15
+}
+
+// maskPhone keeps formatting and the last two digits: +xx xxx xxx xx12
+func maskPhone(match []byte) []byte {
+	ret := make([]byte, len(match))
+	copy(ret, match)
+	keep := 2
+	for i := len(ret) - 1; i >= 0; i-- {
+		if ret[i] < '0' || ret[i] > '9' {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		ret[i] = 'x'
+	}
+	return ret
+}
+
+// maskMAC keeps the vendor part (OUI) and zeroes the device part
+func maskMAC(match []byte) []byte {
+	ret := make([]byte, len(match))
+	copy(ret, match)
+	// "aa:bb:cc:" is kept
+	for i := 9; i < len(ret); i++ {
+		if ret[i] != ':' && ret[i] != '-' {
+			ret[i] = '0'
+		}
+	}
+	return ret
+}
+
+// luhn validates IMEI check digit
+func luhn(match []byte) bool {
+	sum := 0
+	for i := len(match) - 1; i >= 0; i-- {
+		digit := int(match[i] - '0')
+		if (len(match)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// maskIMEI keeps the type allocation code, which identifies the device model
+func maskIMEI(match []byte) []byte {
+	return append(append([]byte{}, match[:8]...), bytes.Repeat([]byte("0"), len(match)-8)...)
+}
+
+// maskUUID replaces advertising IDs with the zero ID reported by devices with limited ad tracking
+func maskUUID(match []byte) []byte {
+	return []byte("00000000-0000-0000-0000-000000000000")
+}
+
+var (
+	coordinatesKeys = map[string]bool{
+		"lat": true, "latitude": true,
+		"lng": true, "lon": true, "long": true, "longitude": true,
+	}
+	// a single coordinate of a property named as coordinate
+	coordinateRegex = regexp.MustCompile(`^-?[0-9]{1,3}\.[0-9]{2,}$`)
+	// "lat,long" pair in any value
+	coordinatesPairRegex = regexp.MustCompile(`-?[0-9]{1,2}\.[0-9]{2,}, ?-?[0-9]{1,3}\.[0-9]{2,}`)
+	// coordinate property in a raw message
+	coordinatesPropertyRegex = regexp.MustCompile(`(?i)"(?:lat|latitude|lng|lon|long|longitude)"\s*:\s*"?(-?[0-9]{1,3}\.[0-9]{2,})`)
+	coordinatesPrecision     = regexp.MustCompile(`([0-9]+\.[0-9])[0-9]+`)
+)
+
+// coordinatesDetector finds precise coordinates, numeric values are passed
+// by the gdpr stage to the detector as well
+type coordinatesDetector struct{}
+
+func (d *coordinatesDetector) Find(key string, value []byte) [][]int {
+	if key == "" {
+		var spans [][]int
+		for _, submatch := range coordinatesPropertyRegex.FindAllSubmatchIndex(value, -1) {
+			spans = append(spans, submatch[2:4])
+		}
+		spans = append(spans, coordinatesPairRegex.FindAllIndex(value, -1)...)
+		sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+		return spans
+	}
+	if coordinatesKeys[strings.ToLower(key)] && coordinateRegex.Match(value) {
+		return [][]int{{0, len(value)}}
+	}
+	return coordinatesPairRegex.FindAllIndex(value, -1)
+}
+
+// Mask rounds coordinates to one decimal, ~11km
+func (d *coordinatesDetector) Mask(match []byte) []byte {
+	return coordinatesPrecision.ReplaceAll(match, []byte("$1"))
+}
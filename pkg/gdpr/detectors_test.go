@@ -0,0 +1,103 @@
+package gdpr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		detector string
+		key      string
+		value    string
+		expected string
+	}{
+		{"email", "", `mail me at john.doe+tag@mail.example.com now`, `mail me at ***@mail.example.com now`},
+		{"email", "", `no email @ here`, `no email @ here`},
+		{"phone", "", `call +1 (415) 555-2671`, `call +x (xxx) xxx-xx71`},
+		{"phone", "", `+14155552671`, `+xxxxxxxxx71`},
+		{"phone", "", `version +1.2`, `version +1.2`},
+		{"mac", "", `mac=00:1A:2b:3c:4D:5e;`, `mac=00:1A:2b:00:00:00;`},
+		{"mac", "", `00-1a-2b-3c-4d-5e`, `00-1a-2b-00-00-00`},
+		{"imei", "", `imei 490154203237518`, `imei 490154200000000`},
+		{"imei", "", `ts 490154203237519`, `ts 490154203237519`},
+		{"adid", "", `gaid=38400000-8cf0-11bd-b23e-10b96e40000d`, `gaid=00000000-0000-0000-0000-000000000000`},
+		{"coordinates", "", `{"lat": 50.433300, "lng":"30.516700"}`, `{"lat": 50.4, "lng":"30.5"}`},
+		{"coordinates", "", `ll=50.4333,30.5167`, `ll=50.4,30.5`},
+		{"coordinates", "latitude", `-33.868820`, `-33.8`},
+		{"coordinates", "version", `33.868820`, `33.868820`},
+		{"coordinates", "location", `50.4333, 30.5167`, `50.4, 30.5`},
+	}
+	for _, test := range tests {
+		detector, err := getDetector(test.detector)
+		require.NoError(t, err)
+		value := []byte(test.value)
+		got := maskSpans(value, detector.Find(test.key, value), detector)
+		assert.Equalf(t, test.expected, string(got), "detector %s", test.detector)
+	}
+}
+
+type secretDetector struct{}
+
+func (d *secretDetector) Find(key string, value []byte) [][]int {
+	if key == "secret" {
+		return [][]int{{0, len(value)}}
+	}
+	return nil
+}
+
+func (d *secretDetector) Mask(match []byte) []byte {
+	return []byte("-")
+}
+
+func TestEventIterator_Detectors(t *testing.T) {
+	RegisterDetector("test_secret", &secretDetector{})
+	assert.Contains(t, DetectorNames(), "test_secret")
+
+	policies, err := NewPolicies(Config{
+		Topics: map[string]PolicyConfig{
+			"contacts": {Detectors: []string{"email", "phone"}},
+			"devices": {
+				Detectors: []string{IPDetector, "coordinates", "test_secret"},
+				Fields:    []FieldConfig{{Path: "payload.debug", ActionConfig: ActionConfig{Action: ActionKeep}}},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		topic    string
+		raw      string
+		expected string
+	}{
+		{
+			topic:    "contacts",
+			raw:      `{"ip":"1.2.3.4","email":"a@b.io","phone":"+14155552671"}`,
+			expected: `{"ip":"1.2.3.4","email":"***@b.io","phone":"+xxxxxxxxx71"}`,
+		},
+		{
+			topic:    "devices",
+			raw:      `{"ip":"1.2.3.4","email":"a@b.io","payload":{"lat":50.4333,"lon":30.5167,"secret":"x","debug":{"lat":50.4333}}}`,
+			expected: `{"ip":"0.0.0.0","email":"a@b.io","payload":{"lat":50.4,"lon":30.5,"secret":"-","debug":{"lat":50.4333}}}`,
+		},
+		{
+			topic:    "other",
+			raw:      `{"ip":"1.2.3.4","email":"a@b.io"}`,
+			expected: `{"ip":"0.0.0.0","email":"a@b.io"}`,
+		},
+	}
+	for _, test := range tests {
+		iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(test.raw)), test.topic), nil).
+			WithPolicies(policies)
+		require.True(t, iter.Next())
+		assert.Equal(t, test.expected, string(iter.At().Message))
+	}
+
+	_, err = NewPolicies(Config{Default: PolicyConfig{Detectors: []string{"unknown"}}})
+	assert.Error(t, err)
+}
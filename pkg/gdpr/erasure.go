@@ -0,0 +1,266 @@
+package gdpr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/anchorfree/data-go/pkg/consul"
+	"github.com/anchorfree/data-go/pkg/file_watcher"
+	"github.com/anchorfree/data-go/pkg/logger"
+	"github.com/anchorfree/data-go/pkg/types"
+)
+
+const (
+	// ErasureDrop drops events of erased users
+	ErasureDrop = "drop"
+	// ErasureScrub removes the user identifier and the scrub fields from events of erased users
+	ErasureScrub = "scrub"
+
+	erasureFalsePositiveRate = 0.01
+)
+
+type ErasureConfig struct {
+	// Dot separated JSON path of the user identifier
+	UserIDPath string `yaml:"user_id_path"`
+	// One of drop, scrub. Defaults to drop
+	Action string `yaml:"action"`
+	// Dot separated paths of fields removed by the scrub action along with the user identifier
+	ScrubFields []string `yaml:"scrub_fields"`
+	// Erasure list sources, one identifier per line, lines starting with # are ignored
+	File          string `yaml:"file"`
+	ConsulAddress string `yaml:"consul_address"`
+	ConsulKeyPath string `yaml:"consul_key_path"`
+}
+
+// ErasureList is a set of erased user identifiers. The bloom filter rejects
+// most identifiers, the rest are looked up in the sorted exact set.
+type ErasureList struct {
+	mx      sync.RWMutex
+	filter  *bloomFilter
+	ids     []string
+	watcher *file_watcher.T
+}
+
+func NewErasureList() *ErasureList {
+	l := &ErasureList{}
+	l.ApplyIDs(nil)
+	return l
+}
+
+func (l *ErasureList) ApplyIDs(ids []string) {
+	sorted := make([]string, 0, len(ids))
+	filter := newBloomFilter(len(ids), erasureFalsePositiveRate)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		sorted = append(sorted, id)
+		filter.add([]byte(id))
+	}
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	l.mx.Lock()
+	l.filter = filter
+	l.ids = unique
+	l.mx.Unlock()
+}
+
+func (l *ErasureList) Contains(id []byte) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	if !l.filter.mayContain(id) {
+		return false
+	}
+	i := sort.Search(len(l.ids), func(i int) bool { return l.ids[i] >= string(id) })
+	return i < len(l.ids) && l.ids[i] == string(id)
+}
+
+func (l *ErasureList) Len() int {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return len(l.ids)
+}
+
+// FromFile loads the list from the file and reloads it on change
+func (l *ErasureList) FromFile(file string) error {
+	if err := l.loadFile(file); err != nil {
+		return err
+	}
+	watcher, err := file_watcher.New(file, func(string) {
+		if err := l.loadFile(file); err != nil {
+			logger.Get().Errorf("Could not reload erasure list from %s: %v", file, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	l.watcher = watcher
+	return nil
+}
+
+// Close stops reloading the list from the file
+func (l *ErasureList) Close() error {
+	if l.watcher == nil {
+		return nil
+	}
+	return l.watcher.Close()
+}
+
+func (l *ErasureList) loadFile(file string) error {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	return l.updateConfig(data)
+}
+
+// RunConfigWatcher loads the list from the consul key
+func (l *ErasureList) RunConfigWatcher(consulAddress string, consulKeyPath string) error {
+	client, err := consul.NewClient(consulAddress)
+	if err != nil {
+		return err
+	}
+	watcher := consul.NewWatcher(client, nil)
+	watcher.Watch(consulKeyPath, l.updateConfig)
+	return nil
+}
+
+func (l *ErasureList) updateConfig(rawConfig []byte) error {
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(rawConfig))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	l.ApplyIDs(ids)
+	logger.Get().Infof("Loaded %d erasure list entries", l.Len())
+	return nil
+}
+
+// Erasure suppresses events of users in the erasure list
+type Erasure struct {
+	list       *ErasureList
+	userIDPath []string
+	action     string
+	scrubPaths [][]string
+}
+
+// NewErasure creates the stage and loads the list from the configured sources
+func NewErasure(config ErasureConfig) (*Erasure, error) {
+	if config.UserIDPath == "" {
+		return nil, fmt.Errorf("erasure user id path is not set")
+	}
+	e := &Erasure{
+		list:       NewErasureList(),
+		userIDPath: strings.Split(config.UserIDPath, "."),
+		action:     config.Action,
+	}
+	switch config.Action {
+	case "":
+		e.action = ErasureDrop
+	case ErasureDrop, ErasureScrub:
+	default:
+		return nil, fmt.Errorf("unknown erasure action: %s", config.Action)
+	}
+	e.scrubPaths = append(e.scrubPaths, e.userIDPath)
+	for _, field := range config.ScrubFields {
+		e.scrubPaths = append(e.scrubPaths, strings.Split(field, "."))
+	}
+	if config.File != "" {
+		if err := e.list.FromFile(config.File); err != nil {
+			return nil, err
+		}
+	}
+	if config.ConsulKeyPath != "" {
+		if err := e.list.RunConfigWatcher(config.ConsulAddress, config.ConsulKeyPath); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *Erasure) List() *ErasureList {
+	return e.list
+}
+
+type ErasureIterator struct {
+	iterator types.EventIterator
+	erasure  *Erasure
+	event    *types.Event
+	err      error
+	parser   fastjson.Parser
+}
+
+var _ types.EventIterator = (*ErasureIterator)(nil)
+
+func (e *Erasure) NewIterator(eventIterator types.EventIterator) *ErasureIterator {
+	return &ErasureIterator{
+		iterator: eventIterator,
+		erasure:  e,
+	}
+}
+
+// Next skips dropped events, events that are not JSON objects or have no user identifier pass as is
+func (ei *ErasureIterator) Next() bool {
+	for ei.iterator.Next() {
+		ei.event = ei.iterator.At()
+		root, err := ei.parser.ParseBytes(ei.event.Message)
+		if err != nil || !ei.erasure.list.Contains(userID(root.Get(ei.erasure.userIDPath...))) {
+			return true
+		}
+		observeErasure(ei.event.Topic, ei.erasure.action)
+		if ei.erasure.action == ErasureDrop {
+			continue
+		}
+		for _, path := range ei.erasure.scrubPaths {
+			if parent := root.Get(path[:len(path)-1]...); parent != nil {
+				parent.Del(path[len(path)-1])
+			}
+		}
+		ei.event.Message = root.MarshalTo(nil)
+		return true
+	}
+	ei.err = ei.iterator.Err()
+	return false
+}
+
+func (ei *ErasureIterator) At() *types.Event {
+	return ei.event
+}
+
+func (ei *ErasureIterator) Err() error {
+	return ei.err
+}
+
+// userID returns string and number identifiers
+func userID(v *fastjson.Value) []byte {
+	if v == nil {
+		return nil
+	}
+	switch v.Type() {
+	case fastjson.TypeString:
+		return v.GetStringBytes()
+	case fastjson.TypeNumber:
+		return v.MarshalTo(nil)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package gdpr
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+func newTestErasure(t *testing.T, config ErasureConfig) *Erasure {
+	t.Helper()
+	config.File = filepath.Join(t.TempDir(), "erasure.txt")
+	require.NoError(t, os.WriteFile(config.File, []byte("# erased users\nu-1\n\n 42 \nu-1\n"), 0600))
+	erasure, err := NewErasure(config)
+	require.NoError(t, err)
+	return erasure
+}
+
+func collectMessages(t *testing.T, erasure *Erasure, raw string, topic string) []string {
+	t.Helper()
+	iter := erasure.NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), topic))
+	var messages []string
+	for iter.Next() {
+		messages = append(messages, string(iter.At().Message))
+	}
+	require.NoError(t, iter.Err())
+	return messages
+}
+
+const erasureEvents = `{"user":{"id":"u-1"},"email":"a@b.io","event":"open"}
+{"user":{"id":42},"email":"c@d.io","event":"open"}
+{"user":{"id":"u-2"},"email":"e@f.io","event":"open"}
+{"event":"anonymous"}
+not json`
+
+func TestErasure_Drop(t *testing.T) {
+	erasure := newTestErasure(t, ErasureConfig{UserIDPath: "user.id"})
+	assert.Equal(t, 2, erasure.List().Len())
+
+	dropped := erasureCounter.WithLabelValues("erasure_drop", ErasureDrop)
+	assert.Equal(t, []string{
+		`{"user":{"id":"u-2"},"email":"e@f.io","event":"open"}`,
+		`{"event":"anonymous"}`,
+		`not json`,
+	}, collectMessages(t, erasure, erasureEvents, "erasure_drop"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(dropped))
+
+	// reloaded list
+	require.NoError(t, erasure.List().updateConfig([]byte("u-2")))
+	assert.Len(t, collectMessages(t, erasure, erasureEvents, "erasure_drop"), 4)
+}
+
+func TestErasure_Scrub(t *testing.T) {
+	erasure := newTestErasure(t, ErasureConfig{UserIDPath: "user.id", Action: ErasureScrub, ScrubFields: []string{"email"}})
+	assert.Equal(t, []string{
+		`{"user":{},"event":"open"}`,
+		`{"user":{},"event":"open"}`,
+		`{"user":{"id":"u-2"},"email":"e@f.io","event":"open"}`,
+		`{"event":"anonymous"}`,
+		`not json`,
+	}, collectMessages(t, erasure, erasureEvents, "erasure_scrub"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(erasureCounter.WithLabelValues("erasure_scrub", ErasureScrub)))
+
+	_, err := NewErasure(ErasureConfig{})
+	assert.Error(t, err)
+	_, err = NewErasure(ErasureConfig{UserIDPath: "id", Action: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestErasureList_FalsePositives(t *testing.T) {
+	ids := make([]string, 10000)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user-%d", i)
+	}
+	list := NewErasureList()
+	list.ApplyIDs(ids)
+	for _, id := range ids {
+		require.True(t, list.Contains([]byte(id)))
+	}
+	passed := 0
+	for i := 0; i < 10000; i++ {
+		id := []byte(fmt.Sprintf("other-%d", i))
+		assert.False(t, list.Contains(id))
+		if list.filter.mayContain(id) {
+			passed++
+		}
+	}
+	assert.Truef(t, passed < 300, "bloom filter false positives: %d", passed)
+	assert.False(t, list.Contains([]byte(strings.Repeat("x", 100))))
+}
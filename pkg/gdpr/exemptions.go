@@ -0,0 +1,55 @@
+package gdpr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/anchorfree/data-go/pkg/utils"
+)
+
+// DefaultExemptLabel is the geo label of addresses left as is when no exemptions are configured
+const DefaultExemptLabel = "af"
+
+// exemptions select addresses left as is by geo label or network
+type exemptions struct {
+	labels         []string
+	nets           []*net.IPNet
+	specialPurpose bool
+}
+
+var defaultExemptions = &exemptions{labels: []string{DefaultExemptLabel}}
+
+func newExemptions(config *ExemptionConfig) (*exemptions, error) {
+	if config == nil {
+		return defaultExemptions, nil
+	}
+	e := &exemptions{labels: config.Labels, specialPurpose: config.SpecialPurpose}
+	for _, cidr := range config.CIDRs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt network: %w", err)
+		}
+		e.nets = append(e.nets, ipNet)
+	}
+	return e, nil
+}
+
+func (e *exemptions) containsIP(ip net.IP) bool {
+	if e.specialPurpose && utils.IsSpecialPurpose(ip) {
+		return true
+	}
+	for _, ipNet := range e.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package gdpr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/geo"
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+func TestEventIterator_Exemptions(t *testing.T) {
+	geoSet := geo.NewGeo()
+	geoSet.FromBytes([]byte("10.1.0.0/16 office;\n10.2.0.0/16 partner;\n74.115.4.69 af;"))
+
+	policies, err := NewPolicies(Config{
+		Exemptions: &ExemptionConfig{
+			Labels: []string{"office"},
+			CIDRs:  []string{"192.0.2.0/24", "2001:db8::1"},
+		},
+		Topics: map[string]PolicyConfig{
+			"partners": {Exemptions: &ExemptionConfig{Labels: []string{"partner"}}},
+			"none":     {Exemptions: &ExemptionConfig{}},
+		},
+	})
+	require.NoError(t, err)
+
+	raw := `{"office":"10.1.2.3","partner":"10.2.2.3","af":"74.115.4.69","doc":"192.0.2.7","v6":"[2001:db8::1]:443","other":"8.8.8.8"}`
+	tests := []struct {
+		topic    string
+		expected string
+	}{
+		{"events", `{"office":"10.1.2.3","partner":"0.0.0.0","af":"0.0.0.0","doc":"192.0.2.7","v6":"[2001:db8::1]:443","other":"0.0.0.0"}`},
+		{"partners", `{"office":"0.0.0.0","partner":"10.2.2.3","af":"0.0.0.0","doc":"0.0.0.0","v6":"[::]:443","other":"0.0.0.0"}`},
+		{"none", `{"office":"0.0.0.0","partner":"0.0.0.0","af":"0.0.0.0","doc":"0.0.0.0","v6":"[::]:443","other":"0.0.0.0"}`},
+	}
+
+	exempted := addressCounter.WithLabelValues("office", resultExempted)
+	masked := addressCounter.WithLabelValues("office", resultMasked)
+	exemptedBefore, maskedBefore := testutil.ToFloat64(exempted), testutil.ToFloat64(masked)
+	for _, test := range tests {
+		iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), test.topic), geoSet).
+			WithPolicies(policies)
+		require.True(t, iter.Next())
+		assert.Equal(t, test.expected, string(iter.At().Message), test.topic)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(exempted)-exemptedBefore)
+	assert.Equal(t, 2.0, testutil.ToFloat64(masked)-maskedBefore)
+
+	// no exemptions configured, "af" addresses are kept
+	policies, err = NewPolicies(Config{})
+	require.NoError(t, err)
+	iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), "events"), geoSet).
+		WithPolicies(policies)
+	require.True(t, iter.Next())
+	assert.Equal(t, "74.115.4.69", unmarshal(t, iter.At().Message)["af"])
+
+	_, err = NewPolicies(Config{Exemptions: &ExemptionConfig{CIDRs: []string{"10.0.0.0/33"}}})
+	assert.Error(t, err)
+}
+
+func TestEventIterator_SpecialPurposeExemption(t *testing.T) {
+	policies, err := NewPolicies(Config{Exemptions: &ExemptionConfig{SpecialPurpose: true}})
+	require.NoError(t, err)
+
+	raw := `{"cgnat":"100.64.1.2","ula":"fd00::1","lan":"192.168.1.1","other":"8.8.8.8"}`
+	iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), "events"), nil).
+		WithPolicies(policies)
+	require.True(t, iter.Next())
+	assert.Equal(t, `{"cgnat":"100.64.1.2","ula":"fd00::1","lan":"192.168.1.1","other":"0.0.0.0"}`, string(iter.At().Message))
+}
@@ -2,14 +2,8 @@ package gdpr
 
 import (
 	"net"
-	"regexp"
 )
 
-var ipv4Regex = regexp.MustCompile(`([0-9]{1,3}\.){3}[0-9]{1,3}`)
-
-//no support for IPv4-IPv6 embedded notation
-var ipv6Regex = regexp.MustCompile(`(?i)(?:[^0-9a-f:])(([0-9a-f]{1,4}:){1,7}:|:(:[0-9a-f]{1,4}){1,7}|([0-9a-f]{1,4}:){1,7}[0-9a-f]{0,4}(:[0-9a-f]{1,4}){1,7})(?:[^0-9a-f:])`)
-
 func maskIP(ip net.IP) string {
 	var ipMask net.IPMask
 	if ip.DefaultMask() != nil {
@@ -27,15 +21,25 @@ func ipGDPR(address string) string {
 
 func findIPs(msg []byte) [][]byte {
 	ret := [][]byte{}
-	for _, ip := range ipv4Regex.FindAll(msg, -1) {
-		if net.ParseIP(string(ip)) != nil {
-			ret = append(ret, ip)
-		}
+	for _, span := range findIPSpans(msg) {
+		ret = append(ret, msg[span[0]:span[1]])
 	}
-	for _, matches := range ipv6Regex.FindAllSubmatch(msg, -1) {
-		if len(matches) > 2 && net.ParseIP(string(matches[1])) != nil {
-			ret = append(ret, matches[1])
+	return ret
+}
+
+// findIPSpans returns [start, end) offsets of IPv4 and IPv6 addresses in the message order,
+// including IPv4-embedded IPv6 (::ffff:1.2.3.4), bracketed ([::1]:443) and host:port forms
+func findIPSpans(msg []byte) [][]int {
+	var (
+		ret     [][]int
+		scanner ipScanner
+	)
+	for i := 0; ; {
+		start, end, _, ok := scanner.next(msg, i)
+		if !ok {
+			return ret
 		}
+		ret = append(ret, []int{start, end})
+		i = end
 	}
-	return ret
 }
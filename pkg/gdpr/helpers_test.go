@@ -0,0 +1,171 @@
+package gdpr
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyGDPR_AddressForms(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{`{"ip":"::ffff:1.2.3.4"}`, `{"ip":"::ffff:0.0.0.0"}`},
+		{`{"ip":"::FFFF:10.20.30.40"}`, `{"ip":"::ffff:0.0.0.0"}`},
+		{`{"peer":"1.2.3.4:443"}`, `{"peer":"0.0.0.0:443"}`},
+		{`{"peer":"[2001:db8::1]:443"}`, `{"peer":"[::]:443"}`},
+		{`{"peer":"[::ffff:1.2.3.4]:8080"}`, `{"peer":"[::ffff:0.0.0.0]:8080"}`},
+		{`{"url":"http://1.2.3.4:8080/path?q=1"}`, `{"url":"http://0.0.0.0:8080/path?q=1"}`},
+		{`{"url":"https://[2001:db8::1]/path"}`, `{"url":"https://[::]/path"}`},
+		{`{"url":"https://example.com/?ip=2001:db8::1&x=1.2.3.4"}`, `{"url":"https://example.com/?ip=::&x=0.0.0.0"}`},
+		{`{"link":"fe80::1%eth0"}`, `{"link":"::%eth0"}`},
+		{`seen 2001:db8::1 2001:db8::2 1.2.3.4.`, `seen :: :: 0.0.0.0.`},
+		{`ip:1.2.3.4, addr:2001:db8::1`, `ip:0.0.0.0, addr:::`},
+		{`11.2.3.45 1.2.3.4`, `0.0.0.0 0.0.0.0`},
+		{`at 10:30 build 1.2 hash deadbeef:cafe`, `at 10:30 build 1.2 hash deadbeef:cafe`},
+		// chained and adjacent addresses
+		{`{"pair":"1.2.3.4:5.6.7.8"}`, `{"pair":"0.0.0.0:0.0.0.0"}`},
+		{`1.2.3.4:5.6.7.8:9.10.11.12`, `0.0.0.0:0.0.0.0:0.0.0.0`},
+		{`1.2.3.4:5.6.7.8:443`, `0.0.0.0:0.0.0.0:443`},
+		{`1.2.3.4:2001:db8::1`, `0.0.0.0:::`},
+		{`x1.2.3.4:5.6.7.8`, `x0.0.0.0:0.0.0.0`},
+		{`1.2.3.4/5.6.7.8|9.10.11.12`, `0.0.0.0/0.0.0.0|0.0.0.0`},
+		{`[2001:db8::1]:443,[2001:db8::2]:80`, `[::]:443,[::]:80`},
+	}
+	reader := &EventIterator{}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, string(reader.ApplyGDPR([]byte(test.raw))), test.raw)
+		assert.Equal(t, test.expected, string(reader.ApplyPolicy(DefaultPolicy, []byte(test.raw))), test.raw)
+	}
+}
+
+func TestApplyGDPR_Truncate(t *testing.T) {
+	a, err := newAction(ActionConfig{Action: ActionTruncate}, "")
+	require.NoError(t, err)
+	reader := &EventIterator{}
+	got := reader.replaceIPs(DefaultPolicy, []byte(`[2001:db8:1:2::1]:443 ::ffff:1.2.3.4 1.2.3.4:80`), a)
+	assert.Equal(t, `[2001:db8:1::]:443 ::ffff:1.2.3.0 1.2.3.0:80`, string(got))
+}
+
+// TestApplyGDPR_NoLeaks masks random addresses in random contexts
+// and checks no address but the masked ones remains in the result
+func TestApplyGDPR_NoLeaks(t *testing.T) {
+	contexts := []string{
+		`%s`,
+		`{"ip":"%s"}`,
+		`{"ips":["%s","%[1]s"]}`,
+		`from %s.`,
+		`%s, %[1]s; %[1]s`,
+		`(%s)`,
+		`ip=%s&next=1`,
+		`addr:%s`,
+		`http://%s/path`,
+		`%s%%eth0`,
+		"\t%s\n",
+	}
+	v4Contexts := []string{`%s:443`, `http://%s:8080/`, `tcp://%s:1`}
+	v6Contexts := []string{`[%s]:443`, `http://[%s]:8080/index.html`, `[%s]`}
+
+	rnd := rand.New(rand.NewSource(1))
+	reader := &EventIterator{}
+	for i := 0; i < 20000; i++ {
+		addr, isV6 := randomAddress(rnd)
+		pool := append([]string{}, contexts...)
+		if isV6 {
+			pool = append(pool, v6Contexts...)
+		} else {
+			pool = append(pool, v4Contexts...)
+		}
+		context := pool[rnd.Intn(len(pool))]
+		msg := fmt.Sprintf(context, addr)
+		got := string(reader.ApplyGDPR([]byte(msg)))
+
+		require.NotContainsf(t, got, addr, "address leaked in %q: %q", msg, got)
+		for _, found := range findIPs([]byte(got)) {
+			ip := net.ParseIP(string(found))
+			require.NotNil(t, ip)
+			require.Truef(t, ip.IsUnspecified(), "address %s leaked in %q: %q", found, msg, got)
+		}
+		// syntax around the address is kept
+		masked := "0.0.0.0"
+		if isV6 {
+			masked = "::"
+		}
+		if strings.HasPrefix(addr, "::ffff:") {
+			masked = "::ffff:0.0.0.0"
+		}
+		require.Equalf(t, strings.Replace(msg, addr, masked, -1), got, "unexpected result for %q", msg)
+
+		// an address before a colon is not a label of the one after it
+		if !isV6 {
+			other := randomIPv4(rnd)
+			msg := fmt.Sprintf(`%s:%s`, addr, other)
+			got := string(reader.ApplyGDPR([]byte(msg)))
+			require.Equalf(t, "0.0.0.0:0.0.0.0", got, "address leaked in %q", msg)
+		}
+	}
+}
+
+func randomIPv4(rnd *rand.Rand) string {
+	return fmt.Sprintf("%d.%d.%d.%d", 1+rnd.Intn(254), rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254))
+}
+
+func randomAddress(rnd *rand.Rand) (string, bool) {
+	v4 := randomIPv4(rnd)
+	switch rnd.Intn(5) {
+	case 0, 1:
+		return v4, false
+	case 2:
+		return "::ffff:" + v4, true
+	}
+	ip := make(net.IP, net.IPv6len)
+	rnd.Read(ip)
+	ip[0] = 0x20
+	// leave zero groups, so compressed forms are generated
+	for i := 2; i < net.IPv6len; i += 2 {
+		if rnd.Intn(3) == 0 {
+			ip[i], ip[i+1] = 0, 0
+		}
+	}
+	if ip.To4() != nil {
+		ip[15] |= 1
+	}
+	s := ip.String()
+	if rnd.Intn(2) == 0 {
+		s = strings.ToUpper(s)
+	}
+	return s, true
+}
+
+var benchAddressMsg = []byte(`{"peer":"[2001:db8::1]:443","mapped":"::ffff:74.115.4.69","url":"http://113.203.84.5:8080/path?q=1",` +
+	`"host":"favoriteshoes.us","time":1521800858842,"af_token":"3d416b03616ad3a80000000272677331"}`)
+
+func BenchmarkFindIPSpans(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		findIPSpans(benchAddressMsg)
+	}
+}
+
+func BenchmarkApplyGDPR(b *testing.B) {
+	reader := &EventIterator{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.ApplyGDPR(benchMsg)
+	}
+}
+
+func BenchmarkApplyGDPR_AddressForms(b *testing.B) {
+	reader := &EventIterator{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.ApplyGDPR(benchAddressMsg)
+	}
+}
@@ -2,9 +2,12 @@ package gdpr
 
 import (
 	"bytes"
+	"net"
+
+	"github.com/valyala/fastjson"
+
 	"github.com/anchorfree/data-go/pkg/geo"
 	"github.com/anchorfree/data-go/pkg/types"
-	"net"
 )
 
 type EventIterator struct {
@@ -12,7 +15,16 @@ type EventIterator struct {
 	event    *types.Event
 	err      error
 
-	geoSet *geo.Geo
+	geoSet   *geo.Geo
+	policies *Policies
+	salts    *SaltStore
+	// salt of the current event
+	salt    *Salt
+	parser  fastjson.Parser
+	arena   fastjson.Arena
+	scanner ipScanner
+	// reused for masked messages
+	buf []byte
 }
 
 var _ types.EventIterator = (*EventIterator)(nil)
@@ -24,6 +36,18 @@ func NewIterator(eventIterator types.EventIterator, geoSet *geo.Geo) *EventItera
 	}
 }
 
+// WithPolicies makes the iterator anonymise events according to the policy of their topic
+func (ei *EventIterator) WithPolicies(policies *Policies) *EventIterator {
+	ei.policies = policies
+	return ei
+}
+
+// WithSalts provides salts for the pseudonymize action
+func (ei *EventIterator) WithSalts(salts *SaltStore) *EventIterator {
+	ei.salts = salts
+	return ei
+}
+
 func (ei *EventIterator) Next() bool {
 	if !ei.iterator.Next() {
 		ei.err = ei.iterator.Err()
@@ -31,7 +55,11 @@ func (ei *EventIterator) Next() bool {
 	}
 
 	ei.event = ei.iterator.At()
-	ei.event.Message = ei.ApplyGDPR(ei.event.Message)
+	if ei.policies == nil {
+		ei.event.Message = ei.ApplyGDPR(ei.event.Message)
+	} else {
+		ei.event.Message = ei.ApplyPolicy(ei.policies.Get(ei.event.Topic), ei.event.Message)
+	}
 
 	return true
 }
@@ -45,12 +73,234 @@ func (ei *EventIterator) Err() error {
 }
 
 func (ei *EventIterator) ApplyGDPR(message []byte) []byte {
-	for _, ip := range findIPs(message) {
-		if net.ParseIP(string(ip)) != nil {
-			if ei.geoSet.Get(string(ip)) != "af" {
-				message = bytes.Replace(message, ip, []byte(ipGDPR(string(ip))), -1)
+	return ei.replaceIPs(DefaultPolicy, message, defaultAction)
+}
+
+// scan rewrites personal data found by the policy detectors in the value,
+// addresses are rewritten by the action
+func (ei *EventIterator) scan(policy *Policy, key string, value []byte, a *action) []byte {
+	if a.name == ActionKeep {
+		return value
+	}
+	if policy.scanIPs {
+		value = ei.replaceIPs(policy, value, a)
+	}
+	for _, detector := range policy.detectors {
+		value = maskSpans(value, detector.Find(key, value), detector)
+	}
+	return value
+}
+
+// ApplyPolicy anonymises the message field by field if the policy has field rules
+// or pseudonymizes and the message is a JSON object, otherwise the whole message is scanned.
+// Pseudonymized events get the ID of the salt in effect.
+func (ei *EventIterator) ApplyPolicy(policy *Policy, message []byte) []byte {
+	ei.salt = nil
+	if policy.pseudonymizes && ei.salts != nil {
+		ei.salt, _ = ei.salts.Active()
+	}
+	if len(policy.fields) == 0 && !policy.pseudonymizes {
+		return ei.scan(policy, "", message, policy.action)
+	}
+	root, err := ei.parser.ParseBytes(message)
+	if err != nil || root.Type() != fastjson.TypeObject {
+		return ei.scan(policy, "", message, policy.action)
+	}
+	ei.arena.Reset()
+	if replacement := ei.rewrite(policy, root, nil, policy.action, false); replacement != nil {
+		root = replacement
+	}
+	if ei.salt != nil {
+		root.Set(policy.saltIDField, ei.arena.NewString(ei.salt.ID))
+	}
+	return root.MarshalTo(nil)
+}
+
+// rewrite applies the action to addresses found in string values of the subtree,
+// the most specific field rule wins. Values of fields matched by a rule exactly
+// are hashed or pseudonymized as a whole. It returns a replacement for a changed value.
+func (ei *EventIterator) rewrite(policy *Policy, v *fastjson.Value, path []string, a *action, exact bool) *fastjson.Value {
+	switch v.Type() {
+	case fastjson.TypeString:
+		if a.name == ActionKeep {
+			return nil
+		}
+		value := v.GetStringBytes()
+		if (policy.scanIPs || exact) && ei.scanner.parse(value) {
+			if ei.isExempt(policy, value, ei.scanner.ip[:]) {
+				return nil
+			}
+			ei.buf = ei.appendReplacement(ei.buf[:0], value, ei.scanner.ip[:], a)
+			return ei.arena.NewStringBytes(ei.buf)
+		}
+		if exact {
+			if token, ok := a.token(value, ei.salt); ok {
+				return ei.arena.NewString(token)
+			}
+		}
+		replaced := ei.scan(policy, lastKey(path), value, a)
+		if bytes.Equal(value, replaced) {
+			return nil
+		}
+		return ei.arena.NewStringBytes(replaced)
+	case fastjson.TypeNumber:
+		if a.name == ActionKeep {
+			return nil
+		}
+		if exact {
+			// numeric identifiers are tokenized by their text
+			if token, ok := a.token(v.MarshalTo(nil), ei.salt); ok {
+				return ei.arena.NewString(token)
 			}
 		}
+		if len(policy.detectors) == 0 {
+			return nil
+		}
+		value := v.MarshalTo(nil)
+		replaced := value
+		for _, detector := range policy.detectors {
+			replaced = maskSpans(replaced, detector.Find(lastKey(path), replaced), detector)
+		}
+		if bytes.Equal(value, replaced) {
+			return nil
+		}
+		return ei.arena.NewNumberString(string(replaced))
+	case fastjson.TypeArray:
+		items := v.GetArray()
+		for i, item := range items {
+			if replacement := ei.rewrite(policy, item, path, a, exact); replacement != nil {
+				v.SetArrayItem(i, replacement)
+			}
+		}
+	case fastjson.TypeObject:
+		o := v.GetObject()
+		var (
+			drop         []string
+			replaceKeys  []string
+			replacements []*fastjson.Value
+			// addresses used as keys are rewritten with the action of the object
+			renames map[string]string
+		)
+		o.Visit(func(key []byte, child *fastjson.Value) {
+			if policy.scanIPs && a.name != ActionKeep {
+				if renamed := ei.replaceIPs(policy, key, a); !bytes.Equal(renamed, key) {
+					if renames == nil {
+						renames = make(map[string]string)
+					}
+					renames[string(key)] = string(renamed)
+				}
+			}
+			childPath := append(path[:len(path):len(path)], string(key))
+			childAction, childExact := a, false
+			if fieldAction, found := policy.field(childPath); found {
+				childAction, childExact = fieldAction, true
+			}
+			if childAction.name == ActionDrop {
+				drop = append(drop, string(key))
+				return
+			}
+			if replacement := ei.rewrite(policy, child, childPath, childAction, childExact); replacement != nil {
+				replaceKeys = append(replaceKeys, string(key))
+				replacements = append(replacements, replacement)
+			}
+		})
+		for i, key := range replaceKeys {
+			o.Set(key, replacements[i])
+		}
+		for _, key := range drop {
+			o.Del(key)
+		}
+		if renames != nil {
+			// keys can't be renamed in place
+			renamed := ei.arena.NewObject()
+			o.Visit(func(key []byte, child *fastjson.Value) {
+				k := string(key)
+				if name, found := renames[k]; found {
+					k = name
+				}
+				renamed.Set(k, child)
+			})
+			return renamed
+		}
+	}
+	return nil
+}
+
+// replaceIPs finds and rewrites addresses in a single pass, the result is built in a reused buffer
+// and copied once, the message is returned as is if nothing is rewritten
+func (ei *EventIterator) replaceIPs(policy *Policy, message []byte, a *action) []byte {
+	if a.name == ActionKeep {
+		return message
+	}
+	buf := ei.buf[:0]
+	last := 0
+	for i := 0; ; {
+		start, end, ip, ok := ei.scanner.next(message, i)
+		if !ok {
+			break
+		}
+		i = end
+		if ei.isExempt(policy, message[start:end], ip) {
+			continue
+		}
+		buf = append(buf, message[last:start]...)
+		buf = ei.appendReplacement(buf, message[start:end], ip, a)
+		last = end
+	}
+	ei.buf = buf
+	if last == 0 {
+		return message
+	}
+	ret := make([]byte, 0, len(buf)+len(message)-last)
+	ret = append(ret, buf...)
+	return append(ret, message[last:]...)
+}
+
+// appendReplacement appends the rewritten address,
+// masked IPv4-embedded IPv6 addresses keep their ::ffff: form
+func (ei *EventIterator) appendReplacement(buf []byte, text []byte, ip net.IP, a *action) []byte {
+	mapped := ip.To4() != nil && bytes.IndexByte(text, ':') >= 0
+	if a == defaultAction {
+		if mapped {
+			return append(buf, "::ffff:0.0.0.0"...)
+		}
+		if ip.To4() != nil {
+			return append(buf, "0.0.0.0"...)
+		}
+		return append(buf, "::"...)
+	}
+	replaced := a.apply(ip, ei.salt)
+	if mapped && net.ParseIP(replaced) != nil {
+		buf = append(buf, "::ffff:"...)
+	}
+	return append(buf, replaced...)
+}
+
+// isExempt checks the address against the policy exemptions and counts it by its geo label
+func (ei *EventIterator) isExempt(policy *Policy, text []byte, ip net.IP) bool {
+	label, exempted := geo.DefaultValue, false
+	if ei.geoSet != nil {
+		addr := string(text)
+		for _, exemptLabel := range policy.exemptions.labels {
+			if ei.geoSet.Match(addr, exemptLabel) {
+				label, exempted = exemptLabel, true
+				break
+			}
+		}
+		if !exempted {
+			label = ei.geoSet.Get(addr)
+		}
+	}
+	if !exempted {
+		exempted = policy.exemptions.containsIP(ip)
+	}
+	observeAddress(label, exempted)
+	return exempted
+}
+
+func lastKey(path []string) string {
+	if len(path) == 0 {
+		return ""
 	}
-	return message
+	return path[len(path)-1]
 }
@@ -189,20 +189,6 @@ var benchMsg = []byte(`{
 		"client_ts": 1521800918976
 	}`)
 
-func BenchmarkIPv4Regex(b *testing.B) {
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		ipv4Regex.FindAll(benchMsg, -1)
-	}
-}
-
-func BenchmarkIPv6Regex(b *testing.B) {
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		ipv6Regex.FindAll(benchMsg, -1)
-	}
-}
-
 func BenchmarkParseIPv4(b *testing.B) {
 	b.ResetTimer()
 	str := "192.168.12.2"
@@ -0,0 +1,63 @@
+package gdpr
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultExempted = "exempted"
+	resultMasked   = "masked"
+)
+
+var addressCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gdpr_addresses_total",
+		Help: "Number of addresses found by gdpr, by geo label and whether they were exempted or masked",
+	},
+	[]string{"label", "result"},
+)
+
+var erasureCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gdpr_erased_events_total",
+		Help: "Number of events of users in the erasure list, by topic and action",
+	},
+	[]string{"topic", "action"},
+)
+
+// addressCounters caches the counters by geo label, addresses are counted on the hot path
+var addressCounters = struct {
+	sync.RWMutex
+	labels map[string]*[2]prometheus.Counter
+}{labels: make(map[string]*[2]prometheus.Counter)}
+
+func RegisterMetrics(prom *prometheus.Registry) {
+	prom.MustRegister(addressCounter)
+	prom.MustRegister(erasureCounter)
+}
+
+func observeAddress(label string, exempted bool) {
+	addressCounters.RLock()
+	counters, found := addressCounters.labels[label]
+	addressCounters.RUnlock()
+	if !found {
+		counters = &[2]prometheus.Counter{
+			addressCounter.WithLabelValues(label, resultMasked),
+			addressCounter.WithLabelValues(label, resultExempted),
+		}
+		addressCounters.Lock()
+		addressCounters.labels[label] = counters
+		addressCounters.Unlock()
+	}
+	if exempted {
+		counters[1].Inc()
+	} else {
+		counters[0].Inc()
+	}
+}
+
+func observeErasure(topic string, action string) {
+	erasureCounter.With(prometheus.Labels{"topic": topic, "action": action}).Inc()
+}
@@ -0,0 +1,215 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	ActionKeep     = "keep"
+	ActionMask     = "mask"
+	ActionTruncate = "truncate"
+	ActionHash     = "hash"
+	ActionDrop     = "drop"
+	// ActionPseudonymize replaces identifiers with HMAC tokens keyed by the active salt
+	ActionPseudonymize = "pseudonymize"
+
+	DefaultIPv4Prefix = 24
+	DefaultIPv6Prefix = 48
+
+	DefaultSaltIDField = "gdpr_salt_id"
+)
+
+// action rewrites addresses found in a value
+type action struct {
+	name     string
+	ipv4Mask net.IPMask
+	ipv6Mask net.IPMask
+	salt     string
+}
+
+var defaultAction = &action{
+	name:     ActionMask,
+	ipv4Mask: net.CIDRMask(0, 32),
+	ipv6Mask: net.CIDRMask(0, 128),
+}
+
+// Policy describes how addresses are anonymised in events of a topic
+type Policy struct {
+	// applied to addresses outside of configured fields
+	action     *action
+	fields     map[string]*action
+	scanIPs    bool
+	detectors  []Detector
+	exemptions *exemptions
+	// the policy has pseudonymize actions, so events get the salt ID
+	pseudonymizes bool
+	saltIDField   string
+}
+
+// DefaultPolicy masks every address found in the message
+var DefaultPolicy = &Policy{action: defaultAction, scanIPs: true, exemptions: defaultExemptions}
+
+type Policies struct {
+	defaultPolicy *Policy
+	topics        map[string]*Policy
+}
+
+func NewPolicies(config Config) (*Policies, error) {
+	if config.SaltIDField == "" {
+		config.SaltIDField = DefaultSaltIDField
+	}
+	defaultPolicy, err := newPolicy(config.Default, config)
+	if err != nil {
+		return nil, fmt.Errorf("default policy: %w", err)
+	}
+	p := &Policies{
+		defaultPolicy: defaultPolicy,
+		topics:        make(map[string]*Policy, len(config.Topics)),
+	}
+	for topic, policyConfig := range config.Topics {
+		policy, err := newPolicy(policyConfig, config)
+		if err != nil {
+			return nil, fmt.Errorf("policy for topic %s: %w", topic, err)
+		}
+		p.topics[topic] = policy
+	}
+	return p, nil
+}
+
+// Get returns the policy of the topic, the default one if the topic has none
+func (p *Policies) Get(topic string) *Policy {
+	if policy, found := p.topics[topic]; found {
+		return policy
+	}
+	return p.defaultPolicy
+}
+
+func newPolicy(config PolicyConfig, globalConfig Config) (*Policy, error) {
+	salt := globalConfig.Salt
+	policyAction, err := newAction(config.ActionConfig, salt)
+	if err != nil {
+		return nil, err
+	}
+	if policyAction.name == ActionDrop {
+		return nil, fmt.Errorf("%s action is supported for fields only", ActionDrop)
+	}
+	exemptionConfig := globalConfig.Exemptions
+	if config.Exemptions != nil {
+		exemptionConfig = config.Exemptions
+	}
+	policyExemptions, err := newExemptions(exemptionConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{
+		action:        policyAction,
+		exemptions:    policyExemptions,
+		fields:        make(map[string]*action, len(config.Fields)),
+		pseudonymizes: policyAction.name == ActionPseudonymize,
+		saltIDField:   globalConfig.SaltIDField,
+	}
+	if len(config.Detectors) == 0 {
+		policy.scanIPs = true
+	}
+	for _, name := range config.Detectors {
+		if name == IPDetector {
+			policy.scanIPs = true
+			continue
+		}
+		detector, err := getDetector(name)
+		if err != nil {
+			return nil, err
+		}
+		policy.detectors = append(policy.detectors, detector)
+	}
+	for _, field := range config.Fields {
+		if field.Path == "" {
+			return nil, fmt.Errorf("empty field path")
+		}
+		fieldAction, err := newAction(field.ActionConfig, salt)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Path, err)
+		}
+		policy.fields[field.Path] = fieldAction
+		if fieldAction.name == ActionPseudonymize {
+			policy.pseudonymizes = true
+		}
+	}
+	return policy, nil
+}
+
+func newAction(config ActionConfig, salt string) (*action, error) {
+	a := &action{name: config.Action, salt: salt}
+	switch config.Action {
+	case "", ActionMask:
+		return defaultAction, nil
+	case ActionTruncate:
+		ipv4Prefix, ipv6Prefix := config.IPv4Prefix, config.IPv6Prefix
+		if ipv4Prefix == 0 {
+			ipv4Prefix = DefaultIPv4Prefix
+		}
+		if ipv6Prefix == 0 {
+			ipv6Prefix = DefaultIPv6Prefix
+		}
+		if ipv4Prefix < 0 || ipv4Prefix > 32 || ipv6Prefix < 0 || ipv6Prefix > 128 {
+			return nil, fmt.Errorf("invalid truncate prefix: /%d, /%d", ipv4Prefix, ipv6Prefix)
+		}
+		a.ipv4Mask = net.CIDRMask(ipv4Prefix, 32)
+		a.ipv6Mask = net.CIDRMask(ipv6Prefix, 128)
+	case ActionHash:
+		if salt == "" {
+			return nil, fmt.Errorf("%s action requires salt", ActionHash)
+		}
+	case ActionPseudonymize:
+		a.ipv4Mask, a.ipv6Mask = defaultAction.ipv4Mask, defaultAction.ipv6Mask
+	case ActionKeep, ActionDrop:
+	default:
+		return nil, fmt.Errorf("unknown action: %s", config.Action)
+	}
+	return a, nil
+}
+
+// apply returns the replacement of the address,
+// the pseudonymize action falls back to mask if there is no active salt
+func (a *action) apply(ip net.IP, salt *Salt) string {
+	switch {
+	case a.name == ActionKeep:
+		return ip.String()
+	case a.name == ActionHash || a.name == ActionPseudonymize && salt != nil:
+		token, _ := a.token([]byte(ip.String()), salt)
+		return token
+	}
+	if ip.To4() != nil {
+		return ip.Mask(a.ipv4Mask).String()
+	}
+	return ip.Mask(a.ipv6Mask).String()
+}
+
+// token returns the replacement of a whole identifier for hash and pseudonymize actions,
+// identifiers are erased if there is no active salt
+func (a *action) token(value []byte, salt *Salt) (string, bool) {
+	switch a.name {
+	case ActionHash:
+		sum := sha256.Sum256(append([]byte(a.salt), value...))
+		return hex.EncodeToString(sum[:16]), true
+	case ActionPseudonymize:
+		if salt == nil {
+			return "", true
+		}
+		return pseudonym(salt, value), true
+	}
+	return "", false
+}
+
+// field returns the action configured for the path
+func (p *Policy) field(path []string) (*action, bool) {
+	if len(p.fields) == 0 {
+		return nil, false
+	}
+	a, found := p.fields[strings.Join(path, ".")]
+	return a, found
+}
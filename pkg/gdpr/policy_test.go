@@ -0,0 +1,121 @@
+package gdpr
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/geo"
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+var testPolicyConfig = []byte(`
+salt: pepper
+default:
+  action: mask
+topics:
+  events:
+    action: truncate
+    fields:
+      - path: from_ip
+        action: hash
+      - path: payload.via
+        action: keep
+      - path: debug
+        action: drop
+      - path: payload.hops
+        action: truncate
+        ipv4_prefix: 16
+        ipv6_prefix: 32
+`)
+
+func newTestPolicies(t *testing.T) *Policies {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "gdpr.yaml")
+	require.NoError(t, os.WriteFile(file, testPolicyConfig, 0600))
+	config, err := LoadConfig(file)
+	require.NoError(t, err)
+	policies, err := NewPolicies(*config)
+	require.NoError(t, err)
+	return policies
+}
+
+func TestPolicies_Get(t *testing.T) {
+	policies := newTestPolicies(t)
+	assert.Equal(t, ActionMask, policies.Get("other").action.name)
+	assert.Equal(t, ActionTruncate, policies.Get("events").action.name)
+}
+
+func TestNewPolicies_Errors(t *testing.T) {
+	configs := []Config{
+		{Default: PolicyConfig{ActionConfig: ActionConfig{Action: "scramble"}}},
+		{Default: PolicyConfig{ActionConfig: ActionConfig{Action: ActionDrop}}},
+		{Default: PolicyConfig{ActionConfig: ActionConfig{Action: ActionHash}}},
+		{Default: PolicyConfig{ActionConfig: ActionConfig{Action: ActionTruncate, IPv4Prefix: 33}}},
+		{Topics: map[string]PolicyConfig{"test": {Fields: []FieldConfig{{Path: ""}}}}},
+	}
+	for _, config := range configs {
+		_, err := NewPolicies(config)
+		assert.Errorf(t, err, "config %+v", config)
+	}
+}
+
+func TestAction_Apply(t *testing.T) {
+	truncate, err := newAction(ActionConfig{Action: ActionTruncate}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "193.43.210.0", truncate.apply(net.ParseIP("193.43.210.30"), nil))
+	assert.Equal(t, "2001:db8:a0b::", truncate.apply(net.ParseIP("2001:db8:a0b:12f0::1"), nil))
+
+	hash, err := newAction(ActionConfig{Action: ActionHash}, "pepper")
+	require.NoError(t, err)
+	assert.Len(t, hash.apply(net.ParseIP("193.43.210.30"), nil), 32)
+	assert.Equal(t, hash.apply(net.ParseIP("193.43.210.30"), nil), hash.apply(net.ParseIP("193.43.210.30"), nil))
+	assert.NotEqual(t, hash.apply(net.ParseIP("193.43.210.30"), nil), hash.apply(net.ParseIP("193.43.210.31"), nil))
+}
+
+func TestEventIterator_WithPolicies(t *testing.T) {
+	geoSet := geo.NewGeo().FromBytes([]byte("74.115.4.69 af;"))
+	policies := newTestPolicies(t)
+	hashed := policies.Get("events").fields["from_ip"].apply(net.ParseIP("113.203.84.1"), nil)
+
+	tests := []struct {
+		topic    string
+		raw      string
+		expected string
+	}{
+		{
+			topic:    "events",
+			raw:      `{"from_ip":"113.203.84.1","remote":"113.203.84.5","payload":{"via":"1.2.3.4","hops":["10.1.2.3","2001:db8:a0b:12f0::1"]},"debug":{"ip":"1.2.3.4"},"af":"74.115.4.69"}`,
+			expected: `{"from_ip":"` + hashed + `","remote":"113.203.84.0","payload":{"via":"1.2.3.4","hops":["10.1.0.0","2001:db8::"]},"af":"74.115.4.69"}`,
+		},
+		{
+			// addresses used as keys
+			topic:    "events",
+			raw:      `{"113.203.84.1":"a","peers":{"113.203.84.5:443":1},"payload":{"via":{"1.2.3.4":"x"}}}`,
+			expected: `{"113.203.84.0":"a","peers":{"113.203.84.0:443":1},"payload":{"via":{"1.2.3.4":"x"}}}`,
+		},
+		{
+			topic:    "other",
+			raw:      `{"from_ip":"113.203.84.1","payload":{"via":"1.2.3.4"}}`,
+			expected: `{"from_ip":"0.0.0.0","payload":{"via":"0.0.0.0"}}`,
+		},
+		{
+			// not a JSON object, fallback to scanning with the topic action
+			topic:    "events",
+			raw:      `"113.203.84.1"`,
+			expected: `"113.203.84.0"`,
+		},
+	}
+	for _, test := range tests {
+		iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(test.raw)), test.topic), geoSet).
+			WithPolicies(policies)
+		require.True(t, iter.Next())
+		assert.Equal(t, test.expected, string(iter.At().Message))
+		assert.False(t, iter.Next())
+	}
+}
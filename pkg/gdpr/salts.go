@@ -0,0 +1,138 @@
+package gdpr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/anchorfree/data-go/pkg/consul"
+	"github.com/anchorfree/data-go/pkg/file_watcher"
+	"github.com/anchorfree/data-go/pkg/logger"
+)
+
+// Salt is a pseudonymisation key, active from its From time until the next salt starts
+type Salt struct {
+	ID     string    `yaml:"id"`
+	Secret string    `yaml:"secret"`
+	From   time.Time `yaml:"from"`
+}
+
+type SaltsConfig struct {
+	Salts []Salt `yaml:"salts"`
+}
+
+// SaltStore keeps the salt schedule, salts rotate by time without reloads
+type SaltStore struct {
+	mx      sync.RWMutex
+	salts   []Salt
+	now     func() time.Time
+	watcher *file_watcher.T
+}
+
+func NewSaltStore() *SaltStore {
+	return &SaltStore{now: time.Now}
+}
+
+func (s *SaltStore) ApplySalts(salts []Salt) error {
+	sorted := make([]Salt, len(salts))
+	copy(sorted, salts)
+	ids := make(map[string]bool, len(sorted))
+	for _, salt := range sorted {
+		if salt.ID == "" || salt.Secret == "" {
+			return fmt.Errorf("salt must have id and secret")
+		}
+		if ids[salt.ID] {
+			return fmt.Errorf("duplicate salt id: %s", salt.ID)
+		}
+		ids[salt.ID] = true
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From.Before(sorted[j].From) })
+	s.mx.Lock()
+	s.salts = sorted
+	s.mx.Unlock()
+	return nil
+}
+
+// Active returns the salt in effect now
+func (s *SaltStore) Active() (*Salt, bool) {
+	now := s.now()
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	i := sort.Search(len(s.salts), func(i int) bool { return s.salts[i].From.After(now) })
+	if i == 0 {
+		return nil, false
+	}
+	salt := s.salts[i-1]
+	return &salt, true
+}
+
+// FromFile loads salts from the YAML file and reloads them on change
+func (s *SaltStore) FromFile(file string) error {
+	if err := s.loadFile(file); err != nil {
+		return err
+	}
+	watcher, err := file_watcher.New(file, func(string) {
+		if err := s.loadFile(file); err != nil {
+			logger.Get().Errorf("Could not reload salts from %s: %v", file, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	s.watcher = watcher
+	return nil
+}
+
+// Close stops reloading salts from the file
+func (s *SaltStore) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	return s.watcher.Close()
+}
+
+func (s *SaltStore) loadFile(file string) error {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	return s.updateConfig(data)
+}
+
+// RunConfigWatcher loads salts from the consul key
+func (s *SaltStore) RunConfigWatcher(consulAddress string, consulKeyPath string) error {
+	client, err := consul.NewClient(consulAddress)
+	if err != nil {
+		return err
+	}
+	watcher := consul.NewWatcher(client, nil)
+	watcher.Watch(consulKeyPath, s.updateConfig)
+	return nil
+}
+
+func (s *SaltStore) updateConfig(rawConfig []byte) error {
+	config := &SaltsConfig{}
+	if err := yaml.Unmarshal(rawConfig, config); err != nil {
+		return err
+	}
+	if err := s.ApplySalts(config.Salts); err != nil {
+		return err
+	}
+	logger.Get().Infof("Loaded %d gdpr salts", len(config.Salts))
+	return nil
+}
+
+// pseudonym returns a token stable for the salt
+func pseudonym(salt *Salt, value []byte) string {
+	mac := hmac.New(sha256.New, []byte(salt.Secret))
+	_, _ = mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,142 @@
+package gdpr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+var testSalts = []byte(`
+salts:
+  - id: day2
+    secret: second
+    from: 2026-10-20T00:00:00Z
+  - id: day1
+    secret: first
+    from: 2026-10-19T00:00:00Z
+`)
+
+func newTestSaltStore(t *testing.T, now time.Time) *SaltStore {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "salts.yaml")
+	require.NoError(t, os.WriteFile(file, testSalts, 0600))
+	store := NewSaltStore()
+	store.now = func() time.Time { return now }
+	require.NoError(t, store.FromFile(file))
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+	return store
+}
+
+func TestSaltStore_Active(t *testing.T) {
+	store := newTestSaltStore(t, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	_, ok := store.Active()
+	assert.False(t, ok, "no salt before the schedule")
+
+	store.now = func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) }
+	salt, ok := store.Active()
+	require.True(t, ok)
+	assert.Equal(t, "day1", salt.ID)
+
+	store.now = func() time.Time { return time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC) }
+	salt, ok = store.Active()
+	require.True(t, ok)
+	assert.Equal(t, "day2", salt.ID)
+
+	assert.Error(t, store.ApplySalts([]Salt{{ID: "a", Secret: ""}}))
+	assert.Error(t, store.ApplySalts([]Salt{{ID: "a", Secret: "x"}, {ID: "a", Secret: "y"}}))
+}
+
+func TestEventIterator_Pseudonymize(t *testing.T) {
+	policies, err := NewPolicies(Config{
+		Salt: "pepper",
+		Topics: map[string]PolicyConfig{
+			"events": {
+				ActionConfig: ActionConfig{Action: ActionPseudonymize},
+				Fields: []FieldConfig{
+					{Path: "user_id", ActionConfig: ActionConfig{Action: ActionPseudonymize}},
+					{Path: "num_id", ActionConfig: ActionConfig{Action: ActionPseudonymize}},
+					{Path: "acct", ActionConfig: ActionConfig{Action: ActionHash}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	raw := `{"user_id":"u-42","num_id":4242424242,"acct":987654321,"remote":"113.203.84.5","note":"from 113.203.84.5"}`
+	acctSum := sha256.Sum256([]byte("pepper987654321"))
+	acct := hex.EncodeToString(acctSum[:16])
+
+	apply := func(store *SaltStore) map[string]interface{} {
+		iter := NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), "events"), nil).
+			WithPolicies(policies)
+		if store != nil {
+			iter.WithSalts(store)
+		}
+		require.True(t, iter.Next())
+		return unmarshal(t, iter.At().Message)
+	}
+
+	day1 := newTestSaltStore(t, time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC))
+	first := apply(day1)
+	assert.Equal(t, "day1", first[DefaultSaltIDField])
+	assert.Equal(t, pseudonym(&Salt{Secret: "first"}, []byte("u-42")), first["user_id"])
+	assert.Equal(t, pseudonym(&Salt{Secret: "first"}, []byte("4242424242")), first["num_id"])
+	assert.Equal(t, acct, first["acct"])
+	assert.Equal(t, pseudonym(&Salt{Secret: "first"}, []byte("113.203.84.5")), first["remote"])
+	assert.Equal(t, "from "+first["remote"].(string), first["note"], "tokens are stable within the salt period")
+	assert.Equal(t, first, apply(day1))
+
+	day2 := newTestSaltStore(t, time.Date(2026, 10, 20, 1, 0, 0, 0, time.UTC))
+	second := apply(day2)
+	assert.Equal(t, "day2", second[DefaultSaltIDField])
+	assert.NotEqual(t, first["user_id"], second["user_id"])
+
+	// no salts, addresses are masked and identifiers are erased
+	unsalted := apply(nil)
+	assert.NotContains(t, unsalted, DefaultSaltIDField)
+	assert.Equal(t, "", unsalted["user_id"])
+	assert.Equal(t, "", unsalted["num_id"])
+	assert.Equal(t, acct, unsalted["acct"])
+	assert.Equal(t, "0.0.0.0", unsalted["remote"])
+}
+
+// TestApplyPolicy_NumericIdentifiersNoLeaks checks numbers of hashed and pseudonymized fields
+// don't remain in the result
+func TestApplyPolicy_NumericIdentifiersNoLeaks(t *testing.T) {
+	policies, err := NewPolicies(Config{
+		Salt: "pepper",
+		Default: PolicyConfig{Fields: []FieldConfig{
+			{Path: "user_id", ActionConfig: ActionConfig{Action: ActionPseudonymize}},
+			{Path: "acct", ActionConfig: ActionConfig{Action: ActionHash}},
+		}},
+	})
+	require.NoError(t, err)
+	rnd := rand.New(rand.NewSource(1))
+	reader := &EventIterator{}
+	for i := 0; i < 1000; i++ {
+		userID, acct := rnd.Int63(), 100000000+rnd.Int63n(900000000)
+		msg := fmt.Sprintf(`{"user_id":%d,"acct":%d,"n":1}`, userID, acct)
+		got := string(reader.ApplyPolicy(policies.Get("events"), []byte(msg)))
+
+		require.NotContainsf(t, got, fmt.Sprint(userID), "identifier leaked in %q: %q", msg, got)
+		require.NotContainsf(t, got, fmt.Sprint(acct), "identifier leaked in %q: %q", msg, got)
+	}
+}
+
+func unmarshal(t *testing.T, message []byte) map[string]interface{} {
+	t.Helper()
+	var ret map[string]interface{}
+	require.NoError(t, json.Unmarshal(message, &ret))
+	return ret
+}
@@ -0,0 +1,281 @@
+package gdpr
+
+import (
+	"net"
+)
+
+const (
+	charDigit = 1 << iota
+	charHex
+	charColon
+	charDot
+)
+
+// addrChars classifies characters an address can consist of,
+// brackets, ports, zones and URL syntax around the address break a run of them
+var addrChars = func() (table [256]byte) {
+	for c := '0'; c <= '9'; c++ {
+		table[c] = charDigit | charHex
+	}
+	for c := 'a'; c <= 'f'; c++ {
+		table[c] = charHex
+		table[c-'a'+'A'] = charHex
+	}
+	table[':'] = charColon
+	table['.'] = charDot
+	return table
+}()
+
+// ipScanner finds IPv4 and IPv6 addresses in a single pass over a message
+// without regular expressions, addresses are parsed into a reused array
+type ipScanner struct {
+	ip [net.IPv6len]byte
+}
+
+// next returns the bounds and the address of the first address found at or after i.
+// The address is valid until the next call.
+func (s *ipScanner) next(msg []byte, i int) (int, int, net.IP, bool) {
+	for i < len(msg) {
+		if addrChars[msg[i]] == 0 {
+			i++
+			continue
+		}
+		start, separators := i, byte(0)
+		for ; i < len(msg) && addrChars[msg[i]] != 0; i++ {
+			separators |= addrChars[msg[i]]
+		}
+		if separators&(charColon|charDot) == 0 {
+			continue
+		}
+		if from, to, ok := s.match(msg, start, i); ok {
+			return from, to, s.ip[:], true
+		}
+	}
+	return 0, 0, nil, false
+}
+
+// match finds the first address in the run msg[start:end], it handles host:port,
+// label:address and dotted runs longer than an address, e.g. "1.2.3.4.5"
+func (s *ipScanner) match(msg []byte, start int, end int) (int, int, bool) {
+	// trailing dots and single colons are punctuation: "at 1.2.3.4." or "1.2.3.4: ok"
+	for end > start && (msg[end-1] == '.' || msg[end-1] == ':' && (end-start < 2 || msg[end-2] != ':')) {
+		end--
+	}
+	// leading dots and colons, unless it is "::"
+	for start < end && (msg[start] == '.' || msg[start] == ':' && (end-start < 2 || msg[start+1] != ':')) {
+		start++
+	}
+	if end-start < 2 {
+		return 0, 0, false
+	}
+	run := msg[start:end]
+	if s.parse(run) {
+		return start, end, true
+	}
+	firstColon, lastColon, hasDot := -1, -1, false
+	for i, c := range run {
+		switch c {
+		case ':':
+			if firstColon < 0 {
+				firstColon = i
+			}
+			lastColon = i
+		case '.':
+			hasDot = true
+		}
+	}
+	// host:port, IPv6 needs brackets for that, so it has already been split by them
+	if lastColon > 0 && hasDot && isPort(run[lastColon+1:]) && s.parse(run[:lastColon]) {
+		return start, start + lastColon, true
+	}
+	// label:address, e.g. "id:2001:db8::1", an address before the colon isn't a label: "1.2.3.4:5.6.7.8"
+	if firstColon > 0 && firstColon+1 < len(run) && run[firstColon+1] != ':' {
+		if from, to, ok := s.matchIPv4(run[:firstColon]); ok {
+			return start + from, start + to, true
+		}
+		if from, to, ok := s.match(msg, start+firstColon+1, end); ok {
+			return from, to, true
+		}
+	}
+	if !hasDot {
+		return 0, 0, false
+	}
+	if from, to, ok := s.matchIPv4(run); ok {
+		return start + from, start + to, true
+	}
+	return 0, 0, false
+}
+
+// matchIPv4 finds the first dotted quad address in run
+func (s *ipScanner) matchIPv4(run []byte) (int, int, bool) {
+	for i := 0; i < len(run); {
+		n := dottedQuadLen(run[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		if s.parseIPv4(run[i:i+n], s.ip[:]) {
+			return i, i + n, true
+		}
+		i += n
+	}
+	return 0, 0, false
+}
+
+// parse parses the whole b as an address, IPv4 addresses are stored in the IPv4-mapped form
+func (s *ipScanner) parse(b []byte) bool {
+	for _, c := range b {
+		switch c {
+		case ':':
+			return s.parseIPv6(b)
+		case '.':
+			return s.parseIPv4(b, s.ip[:])
+		}
+	}
+	return false
+}
+
+func (s *ipScanner) parseIPv4(b []byte, ip []byte) bool {
+	copy(ip, v4InV6Prefix)
+	for i := 0; i < net.IPv4len; i++ {
+		if i > 0 {
+			if len(b) == 0 || b[0] != '.' {
+				return false
+			}
+			b = b[1:]
+		}
+		n, digits := 0, 0
+		for ; digits < len(b) && digits < 4 && addrChars[b[digits]]&charDigit != 0; digits++ {
+			n = n*10 + int(b[digits]-'0')
+		}
+		if digits == 0 || digits > 3 || n > 0xff {
+			return false
+		}
+		ip[12+i] = byte(n)
+		b = b[digits:]
+	}
+	return len(b) == 0
+}
+
+func (s *ipScanner) parseIPv6(b []byte) bool {
+	ip := s.ip[:]
+	ellipsis := -1
+	if len(b) >= 2 && b[0] == ':' && b[1] == ':' {
+		ellipsis = 0
+		b = b[2:]
+		if len(b) == 0 {
+			for j := range ip {
+				ip[j] = 0
+			}
+			return true
+		}
+	}
+	i := 0
+	for i < net.IPv6len {
+		n, digits := 0, 0
+		for ; digits < len(b) && digits < 5 && addrChars[b[digits]]&charHex != 0; digits++ {
+			n = n<<4 | int(unhex(b[digits]))
+		}
+		if digits == 0 || digits > 4 {
+			return false
+		}
+		// embedded IPv4 address at the end
+		if digits < len(b) && b[digits] == '.' {
+			if ellipsis < 0 && i != net.IPv6len-net.IPv4len || i+net.IPv4len > net.IPv6len {
+				return false
+			}
+			var v4 [net.IPv6len]byte
+			if !s.parseIPv4(b, v4[:]) {
+				return false
+			}
+			copy(ip[i:], v4[12:])
+			i += net.IPv4len
+			b = nil
+			break
+		}
+		ip[i] = byte(n >> 8)
+		ip[i+1] = byte(n)
+		i += 2
+		b = b[digits:]
+		if len(b) == 0 {
+			break
+		}
+		if b[0] != ':' || len(b) == 1 {
+			return false
+		}
+		b = b[1:]
+		if b[0] == ':' {
+			if ellipsis >= 0 {
+				return false
+			}
+			ellipsis = i
+			b = b[1:]
+			if len(b) == 0 {
+				break
+			}
+		}
+	}
+	if len(b) != 0 {
+		return false
+	}
+	if i < net.IPv6len {
+		if ellipsis < 0 {
+			return false
+		}
+		n := net.IPv6len - i
+		for j := i - 1; j >= ellipsis; j-- {
+			ip[j+n] = ip[j]
+		}
+		for j := ellipsis + n - 1; j >= ellipsis; j-- {
+			ip[j] = 0
+		}
+	} else if ellipsis >= 0 {
+		return false
+	}
+	return true
+}
+
+var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// dottedQuadLen returns the length of the d.d.d.d prefix of b, up to three digits each
+func dottedQuadLen(b []byte) int {
+	n := 0
+	for group := 0; group < net.IPv4len; group++ {
+		if group > 0 {
+			if n >= len(b) || b[n] != '.' {
+				return 0
+			}
+			n++
+		}
+		digits := 0
+		for ; n < len(b) && digits < 3 && addrChars[b[n]]&charDigit != 0; digits++ {
+			n++
+		}
+		if digits == 0 {
+			return 0
+		}
+	}
+	return n
+}
+
+func isPort(b []byte) bool {
+	if len(b) == 0 || len(b) > 5 {
+		return false
+	}
+	for _, c := range b {
+		if addrChars[c]&charDigit == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
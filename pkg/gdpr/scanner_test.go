@@ -0,0 +1,35 @@
+package gdpr
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPScanner_Parse(t *testing.T) {
+	inputs := []string{
+		"1.2.3.4", "255.255.255.255", "256.1.1.1", "1.2.3", "1.2.3.4.5", "1..2.3", "1.2.3.4.",
+		"::", "::1", "1::", "1:2:3:4:5:6:7:8", "1:2:3:4:5:6:7:8:9", "1:2:3:4:5:6:7::", "::2:3:4:5:6:7:8",
+		"1::2::3", ":1::", "1:::2", "12345::", "fe80::202:b3ff:fe1e:8329", "FE80:0000:0000:0000:0202:B3FF:FE1E:8329",
+		"::ffff:1.2.3.4", "::FFFF:1.2.3.4", "::1.2.3.4", "1:2:3:4:5:6:1.2.3.4", "1:2:3:4:5:6:7:1.2.3.4",
+		"1.2.3.4::", "::ffff:1.2.3", "g::1", "1:", ":",
+	}
+	var s ipScanner
+	for _, input := range inputs {
+		expected := net.ParseIP(input)
+		ok := s.parse([]byte(input))
+		if assert.Equalf(t, expected != nil, ok, "parse %q", input) && ok {
+			assert.Equalf(t, expected.To16(), net.IP(s.ip[:]), "parse %q", input)
+		}
+	}
+
+	// random addresses in their canonical and expanded forms
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		addr, _ := randomAddress(rnd)
+		assert.Truef(t, s.parse([]byte(addr)), "parse %q", addr)
+		assert.Equalf(t, net.ParseIP(addr).To16(), net.IP(s.ip[:]), "parse %q", addr)
+	}
+}
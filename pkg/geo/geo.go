@@ -2,6 +2,7 @@ package geo
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/anchorfree/data-go/pkg/consul"
 	"github.com/anchorfree/data-go/pkg/logger"
 
 	"github.com/fsnotify/fsnotify"
@@ -20,8 +22,41 @@ type Geo struct {
 	geoMux  *sync.RWMutex
 	watcher *fsnotify.Watcher
 	//afGeoFile  string
-	rangers      map[string]cidranger.Ranger
+	data *geoData
+	// file or consul key the data is loaded from
+	source       string
 	defaultValue string
+	metrics      *metrics
+}
+
+// entry is a network with its labels in the order of the geo file
+type entry struct {
+	ipNet    net.IPNet
+	labels   []string
+	metadata map[string]string
+}
+
+func (e *entry) Network() net.IPNet {
+	return e.ipNet
+}
+
+// Counts are numbers of labelled networks by address family
+type Counts struct {
+	IPv4 int
+	IPv6 int
+}
+
+func (c Counts) Total() int {
+	return c.IPv4 + c.IPv6
+}
+
+// geoData is loaded geo data with counts computed at load time, it is never modified
+type geoData struct {
+	ranger cidranger.Ranger
+	// valid lines of the geo data
+	records int
+	total   Counts
+	labels  map[string]Counts
 }
 
 var DefaultValue = "-"
@@ -32,53 +67,23 @@ func NewGeo() *Geo {
 	var g Geo
 	g.defaultValue = DefaultValue
 	g.geoMux = &sync.RWMutex{}
+	g.metrics = newMetrics()
 	return &g
 }
 
+// FromFile loads the geo file and reloads it on change, the service fails if the first load fails
 func (g *Geo) FromFile(file string) *Geo {
 	var err error
 	g.GeoFile = file
-	g.loadFileData()
+	g.source = file
+	if err = g.Reload(); err != nil {
+		logger.Get().Fatal(err)
+	}
 	g.watcher, err = fsnotify.NewWatcher()
 	if err != nil {
 		logger.Get().Fatal(err)
 	}
-	go func(geo *Geo) {
-		timeoutAfterLastEvent := 5 * time.Second
-		defer logger.Get().Error("File watcher shutdown")
-		timers := map[string]*time.Timer{}
-		for {
-			select {
-			case event, ok := <-geo.watcher.Events:
-				if !ok {
-					logger.Get().Error("File watcher not OK")
-					return
-				}
-				if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-					if event.Name == geo.GeoFile {
-						logger.Get().Debugf("modified file (%v): %s", event.Op, event.Name)
-						if timer, found := timers[event.Name]; !found || !timer.Reset(timeoutAfterLastEvent) {
-							if found {
-								timers[event.Name].Stop()
-							}
-							switch event.Name {
-							case geo.GeoFile:
-								timers[event.Name] = time.AfterFunc(timeoutAfterLastEvent, func() { geo.loadFileData() })
-							default:
-								logger.Get().Errorf("Unknown modified file to process: %s", event.Name)
-							}
-						}
-					}
-				}
-			case err, ok := <-geo.watcher.Errors:
-				logger.Get().Debugf("error: %v", err)
-				if !ok {
-					logger.Get().Debug("watcher not OK")
-					return
-				}
-			}
-		}
-	}(g)
+	go g.watch(g.watcher)
 
 	for _, file := range []string{g.GeoFile} {
 		logger.Get().Debugf("Watching %s file", file)
@@ -90,108 +95,323 @@ func (g *Geo) FromFile(file string) *Geo {
 	return g
 }
 
+// watch reloads the geo file on change until the watcher is closed
+func (g *Geo) watch(watcher *fsnotify.Watcher) {
+	timeoutAfterLastEvent := 5 * time.Second
+	timers := map[string]*time.Timer{}
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+		logger.Get().Infof("Geo file watcher shutdown")
+	}()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
+				if event.Name == g.GeoFile {
+					logger.Get().Debugf("modified file (%v): %s", event.Op, event.Name)
+					if timer, found := timers[event.Name]; !found || !timer.Reset(timeoutAfterLastEvent) {
+						if found {
+							timers[event.Name].Stop()
+						}
+						timers[event.Name] = time.AfterFunc(timeoutAfterLastEvent, g.loadFileData)
+					}
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Get().Debugf("error: %v", err)
+		}
+	}
+}
+
+// Close stops watching the geo file
+func (g *Geo) Close() error {
+	if g.watcher == nil {
+		return nil
+	}
+	return g.watcher.Close()
+}
+
 func (g *Geo) loadFileData() {
+	if err := g.Reload(); err != nil {
+		logger.Get().Errorf("%v, keeping the previous geo data", err)
+	}
+}
+
+// Reload loads the geo file, the current data is kept if the file can't be read
+// or has no valid records while the current data has
+func (g *Geo) Reload() error {
 	logger.Get().Infof("Loading AF geoip data from: %s", g.GeoFile)
 	data, err := ReadFile(g.GeoFile)
 	if err != nil {
-		logger.Get().Fatalf("Error loading geo data from %s: %v", g.GeoFile, err)
-		return
+		g.metrics.reloadFailures.Inc()
+		return fmt.Errorf("could not load geo data from %s: %w", g.GeoFile, err)
+	}
+	return g.load(*data)
+}
+
+// FromConsul loads geo data from the consul key and reloads it on change
+func (g *Geo) FromConsul(consulAddress string, consulKeyPath string) error {
+	client, err := consul.NewClient(consulAddress)
+	if err != nil {
+		return err
+	}
+	g.source = "consul:" + consulKeyPath
+	watcher := consul.NewWatcher(client, nil)
+	watcher.Watch(consulKeyPath, g.updateConfig)
+	return nil
+}
+
+func (g *Geo) updateConfig(rawConfig []byte) error {
+	if err := g.load(rawConfig); err != nil {
+		return fmt.Errorf("%w, keeping the previous geo data", err)
+	}
+	return nil
+}
+
+// load applies the data unless it has no valid records while the current data has
+func (g *Geo) load(data []byte) error {
+	parsed := g.parse(data)
+	g.geoMux.RLock()
+	hadRecords := g.data != nil && g.data.records > 0
+	g.geoMux.RUnlock()
+	if parsed.records == 0 && hadRecords {
+		g.metrics.reloadFailures.Inc()
+		return fmt.Errorf("could not load geo data from %s: no valid records", g.source)
 	}
-	g.FromBytes(*data)
+	g.apply(parsed)
+	return nil
 }
 
 func (g *Geo) FromBytes(data []byte) *Geo {
-	rangers := map[string]cidranger.Ranger{}
+	g.apply(g.parse(data))
+	return g
+}
+
+func (g *Geo) apply(data *geoData) {
+	g.geoMux.Lock()
+	g.data = data
+	g.geoMux.Unlock()
+	g.metrics.lastReload.SetToCurrentTime()
+	g.metrics.records.Set(float64(data.records))
+	g.metrics.networks.Reset()
+	for label, counts := range data.labels {
+		g.metrics.networks.WithLabelValues(label, familyIPv4).Set(float64(counts.IPv4))
+		g.metrics.networks.WithLabelValues(label, familyIPv6).Set(float64(counts.IPv6))
+	}
+	logger.Get().Warnf("Loaded %d records from %s", data.total.Total(), g.source)
+}
+
+// parse builds the ranger of the geo data and counts labelled networks
+func (g *Geo) parse(data []byte) *geoData {
+	ranger := cidranger.NewPCTrieRanger()
+	records := 0
+	entries := map[string]*entry{}
 	lines := bytes.Split(data, []byte("\n"))
 	for _, ipline := range lines {
 		trimmedLine := bytes.TrimRight(bytes.TrimSpace(ipline), ";")
 		if len(trimmedLine) > 0 {
-			recordParts := bytes.Split(trimmedLine, []byte(" "))
-			var (
-				ranger  cidranger.Ranger
-				netAddr []byte
-				found   bool
-			)
-			if len(recordParts) == 2 && len(recordParts[1]) > 0 {
-				ranger, found = rangers[string(recordParts[1])]
-				if !found {
-					ranger = cidranger.NewPCTrieRanger()
-				}
+			// address label [key=value ...]
+			recordParts := bytes.Fields(trimmedLine)
+			var netAddr []byte
+			metadata, ok := parseMetadata(recordParts)
+			if ok {
 				addr := bytes.Split(recordParts[0], []byte("/"))
 				if len(addr) == 2 {
 					netAddr = recordParts[0]
 				} else {
 					netAddr = addr[0]
-					netAddr = append(netAddr, []byte("/32")...)
+					if bytes.IndexByte(netAddr, ':') >= 0 {
+						netAddr = append(netAddr, []byte("/128")...)
+					} else {
+						netAddr = append(netAddr, []byte("/32")...)
+					}
 				}
 				_, ipNet, err := net.ParseCIDR(string(netAddr))
 				if err != nil {
-					logger.Get().Warnf("Could not parse IP from geo file %s: %s", g.GeoFile, recordParts[0])
+					logger.Get().Warnf("Could not parse IP from geo data %s: %s", g.source, recordParts[0])
 					continue
 				}
-				_ = ranger.Insert(cidranger.NewBasicRangerEntry(*ipNet))
-				rangers[string(recordParts[1])] = ranger
+				label := string(recordParts[1])
+				e, found := entries[ipNet.String()]
+				if !found {
+					e = &entry{ipNet: *ipNet}
+					entries[ipNet.String()] = e
+					_ = ranger.Insert(e)
+				}
+				records++
+				if !containsLabel(e.labels, label) {
+					e.labels = append(e.labels, label)
+				}
+				for key, value := range metadata {
+					if e.metadata == nil {
+						e.metadata = map[string]string{}
+					}
+					if _, found := e.metadata[key]; !found {
+						e.metadata[key] = value
+					}
+				}
 			} else {
-				logger.Get().Warnf("Malformed geo record in %s: %s", g.GeoFile, ipline)
+				logger.Get().Warnf("Malformed geo record in %s: %s", g.source, ipline)
 			}
 		}
 	}
-	g.geoMux.Lock()
-	g.rangers = rangers
-	g.geoMux.Unlock()
-	logger.Get().Warnf("Loaded %d records from %s", g.Len(), g.GeoFile)
-	return g
+	parsed := &geoData{
+		ranger:  ranger,
+		records: records,
+		labels:  make(map[string]Counts),
+	}
+	for _, e := range entries {
+		isIPv4 := e.ipNet.IP.To4() != nil
+		for _, label := range e.labels {
+			counts := parsed.labels[label]
+			if isIPv4 {
+				counts.IPv4++
+				parsed.total.IPv4++
+			} else {
+				counts.IPv6++
+				parsed.total.IPv6++
+			}
+			parsed.labels[label] = counts
+		}
+	}
+	return parsed
 }
 
-// Returns first match label
+// Get returns the label of the most specific network containing the IP,
+// the label listed first in the geo file wins for the same network
 func (g *Geo) Get(ip string) string {
-	ipAddr := net.ParseIP(ip)
-	if ipAddr == nil {
+	labels := g.Labels(ip)
+	if len(labels) == 0 {
 		return g.defaultValue
 	}
-	g.geoMux.RLock()
-	defer g.geoMux.RUnlock()
-	for label, ranger := range g.rangers {
-		contains, err := ranger.Contains(ipAddr)
-		if err != nil {
-			logger.Get().Warnf("IP Ranger lookup err: %v", err)
+	return labels[0]
+}
+
+// Labels returns labels of all networks containing the IP, the most specific network first
+func (g *Geo) Labels(ip string) []string {
+	var ret []string
+	for _, e := range g.containingEntries(ip) {
+		for _, label := range e.labels {
+			if !containsLabel(ret, label) {
+				ret = append(ret, label)
+			}
 		}
-		if contains {
-			return label
+	}
+	return ret
+}
+
+// Metadata returns extra columns of networks containing the IP, values of more specific networks win
+func (g *Geo) Metadata(ip string) map[string]string {
+	var ret map[string]string
+	for _, e := range g.containingEntries(ip) {
+		for key, value := range e.metadata {
+			if ret == nil {
+				ret = map[string]string{}
+			}
+			if _, found := ret[key]; !found {
+				ret[key] = value
+			}
 		}
 	}
-	return g.defaultValue
+	return ret
 }
 
 func (g *Geo) Match(ip string, label string) bool {
+	for _, e := range g.containingEntries(ip) {
+		if containsLabel(e.labels, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// containingEntries returns entries containing the IP, the most specific first
+func (g *Geo) containingEntries(ip string) []*entry {
 	ipAddr := net.ParseIP(ip)
 	if ipAddr == nil {
-		return false
+		return nil
 	}
+	g.geoMux.RLock()
+	data := g.data
+	g.geoMux.RUnlock()
+	if data == nil {
+		return nil
+	}
+	rangerEntries, err := data.ranger.ContainingNetworks(ipAddr)
+	if err != nil {
+		logger.Get().Warnf("IP Ranger lookup err: %v", err)
+	}
+	ret := make([]*entry, 0, len(rangerEntries))
+	// ranger returns the least specific network first
+	for i := len(rangerEntries) - 1; i >= 0; i-- {
+		ret = append(ret, rangerEntries[i].(*entry))
+	}
+	return ret
+}
+
+// Len returns the number of labelled networks of both address families
+func (g *Geo) Len() int {
+	return g.Counts().Total()
+}
+
+// Counts returns numbers of labelled networks by address family
+func (g *Geo) Counts() Counts {
 	g.geoMux.RLock()
 	defer g.geoMux.RUnlock()
-	ranger, found := g.rangers[label]
-	if found {
-		contains, err := ranger.Contains(ipAddr)
-		if err != nil {
-			logger.Get().Warnf("IP Ranger lookup err: %v", err)
+	if g.data == nil {
+		return Counts{}
+	}
+	return g.data.total
+}
+
+// LabelCounts returns numbers of networks of each label by address family
+func (g *Geo) LabelCounts() map[string]Counts {
+	g.geoMux.RLock()
+	defer g.geoMux.RUnlock()
+	ret := make(map[string]Counts)
+	if g.data == nil {
+		return ret
+	}
+	for label, counts := range g.data.labels {
+		ret[label] = counts
+	}
+	return ret
+}
+
+// parseMetadata checks the record has an address and a label and parses optional key=value columns
+func parseMetadata(recordParts [][]byte) (map[string]string, bool) {
+	if len(recordParts) < 2 {
+		return nil, false
+	}
+	var metadata map[string]string
+	for _, column := range recordParts[2:] {
+		eq := bytes.IndexByte(column, '=')
+		if eq <= 0 {
+			return nil, false
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
 		}
-		return contains
+		metadata[string(column[:eq])] = string(column[eq+1:])
 	}
-	return false
+	return metadata, true
 }
 
-func (g *Geo) Len() int {
-	ret := 0
-	for _, ranger := range g.rangers {
-		_, wildnet, _ := net.ParseCIDR("0.0.0.0/0")
-		rangerEntries, err := ranger.CoveredNetworks(*wildnet)
-		if err != nil {
-			logger.Get().Errorf("IP Ranger ContainingNetworks err: %v", err)
+func containsLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
 		}
-		ret = ret + len(rangerEntries)
 	}
-	return ret
+	return false
 }
 
 func ReadFile(filename string) (*[]byte, error) {
@@ -1,8 +1,13 @@
 package geo
 
 import (
-	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGeo(t *testing.T) {
@@ -21,7 +26,17 @@ func TestGeo(t *testing.T) {
 		::1 lo;
 		`)
 	g := NewGeo().FromBytes(data)
-	assert.Equalf(t, 9, g.Len(), "Amount of IPs does not match")
+	assert.Equalf(t, 11, g.Len(), "Amount of IPs does not match")
+	assert.Equal(t, Counts{IPv4: 9, IPv6: 2}, g.Counts())
+	assert.Equal(t, map[string]Counts{
+		"af":  {IPv4: 7},
+		"aws": {IPv4: 1},
+		"amz": {IPv4: 1},
+		"v6":  {IPv6: 1},
+		"lo":  {IPv6: 1},
+	}, g.LabelCounts())
+	assert.Equal(t, 7.0, testutil.ToFloat64(g.metrics.networks.WithLabelValues("af", familyIPv4)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.networks.WithLabelValues("lo", familyIPv6)))
 	assert.Equalf(t, "af", g.Get("184.170.253.178"), "Did not find af record")
 	assert.Equalf(t, "af", g.Get("107.152.104.4"), "Did not find af record")
 	assert.Truef(t, g.Match("198.8.84.226", "af"), "Did not match af record")
@@ -33,6 +48,85 @@ func TestGeo(t *testing.T) {
 	assert.Truef(t, g.Match("2400::1:2:3", "v6"), "Did not match v6 record")
 }
 
+func TestGeo_LongestPrefix(t *testing.T) {
+	data := []byte(`
+		10.0.0.0/8 corp;
+		10.1.0.0/16 office;
+		10.1.2.0/24 lab;
+		10.1.2.0/24 office;
+		10.1.2.3 router;
+		2001:db8::/32 v6;
+		2001:db8::1 host;
+		`)
+	g := NewGeo().FromBytes(data)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "router", g.Get("10.1.2.3"))
+		assert.Equal(t, "lab", g.Get("10.1.2.4"))
+		assert.Equal(t, "office", g.Get("10.1.3.4"))
+		assert.Equal(t, "corp", g.Get("10.2.3.4"))
+	}
+	assert.Equal(t, []string{"router", "lab", "office", "corp"}, g.Labels("10.1.2.3"))
+	assert.Equal(t, []string{"office", "corp"}, g.Labels("10.1.3.4"))
+	assert.Empty(t, g.Labels("8.8.8.8"))
+	assert.Equal(t, []string{"host", "v6"}, g.Labels("2001:db8::1"))
+	assert.Equal(t, "v6", g.Get("2001:db8::2"))
+	assert.True(t, g.Match("10.1.2.3", "corp"))
+	assert.False(t, g.Match("10.2.3.4", "office"))
+}
+
+func TestGeo_Metadata(t *testing.T) {
+	data := []byte(`
+		54.182.0.0/16 aws provider=amazon region=us-east-1;
+		54.182.1.0/24 aws datacenter=iad12 region=us-east-1a;
+		54.182.1.0/24 cf provider=cloudfront;
+		10.0.0.0/8 corp
+		10.1.0.0/16 office floor 2;
+		`)
+	g := NewGeo().FromBytes(data)
+	assert.Equal(t, map[string]string{"provider": "cloudfront", "region": "us-east-1a", "datacenter": "iad12"}, g.Metadata("54.182.1.5"))
+	assert.Equal(t, map[string]string{"provider": "amazon", "region": "us-east-1"}, g.Metadata("54.182.2.5"))
+	assert.Equal(t, []string{"aws", "cf"}, g.Labels("54.182.1.5"))
+	assert.Nil(t, g.Metadata("10.1.2.3"))
+	assert.Equal(t, "corp", g.Get("10.1.2.3"), "malformed records are skipped")
+}
+
+func TestGeo_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "geo.conf")
+	require.NoError(t, os.WriteFile(file, []byte("10.0.0.0/8 corp;\n"), 0600))
+	g := NewGeo().FromFile(file)
+	defer g.Close()
+	assert.Equal(t, "corp", g.Get("10.1.2.3"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.records))
+	assert.NotZero(t, testutil.ToFloat64(g.metrics.lastReload))
+
+	require.NoError(t, os.WriteFile(file, []byte("10.0.0.0/8 corp;\n192.168.0.0/16 home;\n"), 0600))
+	require.NoError(t, g.Reload())
+	assert.Equal(t, "home", g.Get("192.168.1.1"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.records))
+
+	// broken deploys keep the last good data
+	require.NoError(t, os.WriteFile(file, []byte("garbage\n"), 0600))
+	assert.Error(t, g.Reload())
+	require.NoError(t, os.Remove(file))
+	assert.Error(t, g.Reload())
+	assert.Equal(t, "home", g.Get("192.168.1.1"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.records))
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.reloadFailures))
+
+	assert.NoError(t, g.Close())
+}
+
+func TestGeo_ConsulUpdate(t *testing.T) {
+	g := NewGeo()
+	g.source = "consul:geo/ranges"
+	require.NoError(t, g.updateConfig([]byte("10.0.0.0/8 corp;\n")))
+	assert.Equal(t, "corp", g.Get("10.1.2.3"))
+	assert.Error(t, g.updateConfig([]byte("")))
+	assert.Equal(t, "corp", g.Get("10.1.2.3"))
+	require.NoError(t, g.updateConfig([]byte("10.0.0.0/8 lan;\n")))
+	assert.Equal(t, "lan", g.Get("10.1.2.3"))
+}
+
 func BenchmarkGeo(b *testing.B) {
 	g := NewGeo().FromFile("test-data/aws.conf")
 	b.ResetTimer()
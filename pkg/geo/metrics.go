@@ -0,0 +1,45 @@
+package geo
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+type metrics struct {
+	lastReload     prometheus.Gauge
+	records        prometheus.Gauge
+	networks       *prometheus.GaugeVec
+	reloadFailures prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "geo_last_reload_timestamp_seconds",
+			Help: "Time of the last successful geo data load",
+		}),
+		records: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "geo_records",
+			Help: "Number of valid records in the loaded geo data",
+		}),
+		networks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "geo_networks",
+			Help: "Number of networks in the loaded geo data, by label and address family",
+		}, []string{"label", "family"}),
+		reloadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "geo_reload_failures_total",
+			Help: "Number of geo data loads that failed, the previous data is kept",
+		}),
+	}
+}
+
+func (g *Geo) RegisterMetrics(prom *prometheus.Registry) {
+	prom.MustRegister(g.metrics.lastReload)
+	prom.MustRegister(g.metrics.records)
+	prom.MustRegister(g.metrics.networks)
+	prom.MustRegister(g.metrics.reloadFailures)
+}
@@ -1,9 +1,35 @@
 package schema
 
+const (
+	// UnknownTypeReject sends events without a matching schema to the invalid messages topic
+	UnknownTypeReject = "reject"
+	// UnknownTypePass lets events without a matching schema through untouched
+	UnknownTypePass = "pass"
+	// UnknownTypeRoute sends events without a matching schema to the unknown type topic
+	UnknownTypeRoute = "route"
+)
+
 type Config struct {
-	ConsulAddress        string   `yaml:"consul_address"`
-	ConsulKeyPath        string   `yaml:"consul_key_path"`
-	InvalidMessagesTopic string   `yaml:"invalid_messages_topic"`
-	ValidateTopics       []string `yaml:"validate_topics"`
-	PropertyName         string   `yaml:"property_name"`
+	ConsulAddress        string                 `yaml:"consul_address"`
+	ConsulKeyPath        string                 `yaml:"consul_key_path"`
+	InvalidMessagesTopic string                 `yaml:"invalid_messages_topic"`
+	ValidateTopics       []string               `yaml:"validate_topics"`
+	Topics               map[string]TopicConfig `yaml:"topics"`
+	PropertyName         string                 `yaml:"property_name"`
+	UnknownTypePolicy    string                 `yaml:"unknown_type_policy"`
+	UnknownTypeTopic     string                 `yaml:"unknown_type_topic"`
+	// Prepend validation error reason to the rerouted message: "topic\treason\tmessage"
+	AttachErrorDetails bool `yaml:"attach_error_details"`
+}
+
+// TopicConfig binds a topic to its own OpenAPI document and/or a subset of schemas.
+// Topics listed here are validated even if they are missing in ValidateTopics.
+type TopicConfig struct {
+	// Consul key of the topic's own document, the shared document is used if empty
+	ConsulKeyPath string `yaml:"consul_key_path"`
+	// Component schemas the topic is validated against, all of them if empty
+	Schemas []string `yaml:"schemas"`
+	// Repair events before validation: coerce numeric strings, fill defaults
+	// and drop properties not allowed by additionalProperties: false
+	Repair bool `yaml:"repair"`
 }
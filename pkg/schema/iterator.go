@@ -2,6 +2,7 @@ package schema
 
 import (
 	"bytes"
+	"errors"
 
 	"github.com/anchorfree/data-go/pkg/logger"
 	"github.com/anchorfree/data-go/pkg/types"
@@ -10,9 +11,10 @@ import (
 type EventIterator struct {
 	sm *SchemaManager
 
-	iter  types.EventIterator
-	event *types.Event
-	err   error
+	iter          types.EventIterator
+	event         *types.Event
+	validationErr error
+	err           error
 }
 
 func (sm *SchemaManager) NewIterator(iterator types.EventIterator) *EventIterator {
@@ -23,6 +25,7 @@ func (sm *SchemaManager) NewIterator(iterator types.EventIterator) *EventIterato
 }
 
 func (ei *EventIterator) Next() bool {
+	ei.validationErr = nil
 	if !ei.iter.Next() {
 		logger.Get().Debugf("no upstream events")
 		ei.err = ei.iter.Err()
@@ -32,29 +35,65 @@ func (ei *EventIterator) Next() bool {
 	ei.event = ei.iter.At()
 	logger.Get().Debugf("go event from upstream: %s", ei.event)
 
-	if ei.sm.schema == nil {
-		logger.Get().Debugf("empty swagger schema, skip validation")
+	if !ei.sm.IsTopicValidated(ei.event.Topic) {
+		logger.Get().Debugf("topic %s is not selected for validation", ei.event.Topic)
 		return true
 	}
 
-	if _, ok := ei.sm.validateTopics[ei.event.Topic]; !ok {
-		logger.Get().Debugf("topic %s is not selected for validation", ei.event.Topic)
+	set := ei.sm.schemaSet(ei.event.Topic)
+	if set == nil {
+		logger.Get().Debugf("empty swagger schema, skip validation")
 		return true
 	}
 
-	if ok, err := ei.sm.Validate(*ei.event); !ok {
-		logger.Get().Warnf("failed to validate event: %s with error: %#v", ei.event, err)
-		ei.event.Message = bytes.Join([][]byte{[]byte(ei.event.Topic), ei.event.Message}, []byte("\t"))
-		ei.event.Topic = ei.sm.GetInvalidMessagesTopic()
+	eventType, err := ei.sm.validateWith(set, ei.event, ei.sm.IsTopicRepaired(ei.event.Topic))
+	ei.validationErr = err
+	switch {
+	case err == nil:
+		ei.sm.observe(ei.event.Topic, eventType, resultValid)
+	case errors.Is(err, ErrUnknownEventType):
+		ei.sm.observe(ei.event.Topic, "", resultUnknownType)
+		switch ei.sm.GetUnknownTypePolicy() {
+		case UnknownTypePass:
+			logger.Get().Debugf("pass event with unknown type: %s", ei.event)
+		case UnknownTypeRoute:
+			logger.Get().Debugf("route event with unknown type: %s", ei.event)
+			ei.reroute(ei.sm.GetUnknownTypeTopic(), err)
+		default:
+			logger.Get().Warnf("failed to validate event: %s with error: %v", ei.event, err)
+			ei.reroute(ei.sm.GetInvalidMessagesTopic(), err)
+		}
+	default:
+		if eventType == "" {
+			ei.sm.observe(ei.event.Topic, "", resultMalformed)
+		} else {
+			ei.sm.observe(ei.event.Topic, eventType, resultInvalid)
+		}
+		logger.Get().Warnf("failed to validate event: %s with error: %s", ei.event, ErrorDetails(err))
+		ei.reroute(ei.sm.GetInvalidMessagesTopic(), err)
 	}
 
 	return true
 }
 
+func (ei *EventIterator) reroute(topic string, err error) {
+	parts := [][]byte{[]byte(ei.event.Topic)}
+	if ei.sm.config.AttachErrorDetails {
+		parts = append(parts, []byte(ErrorDetails(err)))
+	}
+	ei.event.Message = bytes.Join(append(parts, ei.event.Message), []byte("\t"))
+	ei.event.Topic = topic
+}
+
 func (ei *EventIterator) At() *types.Event {
 	return ei.event
 }
 
+// ValidationErr returns the validation error of the current event, nil if it passed
+func (ei *EventIterator) ValidationErr() error {
+	return ei.validationErr
+}
+
 func (ei *EventIterator) Err() error {
 	return ei.err
 }
@@ -0,0 +1,128 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+	"github.com/anchorfree/data-go/pkg/promutils"
+	"github.com/anchorfree/data-go/pkg/types"
+)
+
+var testSwagger = []byte(`
+openapi: 3.0.0
+info:
+  title: test
+  version: 1.0.0
+paths: {}
+components:
+  schemas:
+    app_start:
+      type: object
+      required:
+        - event
+        - ts
+      properties:
+        event:
+          type: string
+        ts:
+          type: integer
+`)
+
+func newTestManager(t *testing.T, config Config) *SchemaManager {
+	t.Helper()
+	if config.PropertyName == "" {
+		config.PropertyName = "event"
+	}
+	if config.ValidateTopics == nil {
+		config.ValidateTopics = []string{"test"}
+	}
+	sm := NewSchemaManager(config)
+	require.NoError(t, sm.updateConfig(testSwagger))
+	return sm
+}
+
+func collectEvents(sm *SchemaManager, raw string) (topics []string, messages []string) {
+	iter := sm.NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(raw)), "test"))
+	for iter.Next() {
+		topics = append(topics, iter.At().Topic)
+		messages = append(messages, string(iter.At().Message))
+	}
+	return topics, messages
+}
+
+func TestSchemaManager_Validate(t *testing.T) {
+	sm := newTestManager(t, Config{})
+
+	ok, err := sm.Validate(*newEvent(`{"event":"app_start","ts":1}`))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = sm.Validate(*newEvent(`{"event":"app_start","ts":"1"}`))
+	assert.False(t, ok)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(ErrorDetails(err), "/ts: "), ErrorDetails(err))
+
+	ok, err = sm.Validate(*newEvent(`{"event":"app_stop","ts":1}`))
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, ErrUnknownEventType))
+}
+
+func TestEventIterator_UnknownTypePolicy(t *testing.T) {
+	raw := `{"event":"app_start","ts":1}
+{"event":"app_stop","ts":1}`
+	tests := []struct {
+		policy string
+		topics []string
+	}{
+		{policy: "", topics: []string{"test", "malformed"}},
+		{policy: UnknownTypeReject, topics: []string{"test", "malformed"}},
+		{policy: UnknownTypePass, topics: []string{"test", "test"}},
+		{policy: UnknownTypeRoute, topics: []string{"test", "unknown_type"}},
+	}
+	for _, test := range tests {
+		sm := newTestManager(t, Config{UnknownTypePolicy: test.policy})
+		topics, _ := collectEvents(sm, raw)
+		assert.Equalf(t, test.topics, topics, "policy %q", test.policy)
+	}
+}
+
+func TestEventIterator_AttachErrorDetails(t *testing.T) {
+	sm := newTestManager(t, Config{AttachErrorDetails: true})
+	topics, messages := collectEvents(sm, `{"event":"app_start"}`)
+	require.Len(t, messages, 1)
+	assert.Equal(t, []string{"malformed"}, topics)
+	parts := strings.Split(messages[0], "\t")
+	require.Len(t, parts, 3)
+	assert.Equal(t, "test", parts[0])
+	assert.Equal(t, "Property 'ts' is missing", parts[1])
+	assert.Equal(t, `{"event":"app_start"}`, parts[2])
+}
+
+func TestEventIterator_Metrics(t *testing.T) {
+	sm := newTestManager(t, Config{UnknownTypePolicy: UnknownTypePass})
+	collectEvents(sm, `{"event":"app_start","ts":1}
+{"event":"app_start","ts":"1"}
+{"event":"app_stop"}
+not a json`)
+
+	expect := `# HELP schema_validation_events_total Number of events processed by schema validation, by topic, event type and result
+# TYPE schema_validation_events_total counter
+schema_validation_events_total{event_type="-",result="malformed",topic="test"} 1
+schema_validation_events_total{event_type="-",result="unknown_type",topic="test"} 1
+schema_validation_events_total{event_type="app_start",result="invalid",topic="test"} 1
+schema_validation_events_total{event_type="app_start",result="valid",topic="test"} 1
+`
+	rendered, err := promutils.Collect(sm.validationCounter)
+	assert.NoError(t, err)
+	assert.Equal(t, expect, rendered)
+}
+
+func newEvent(message string) *types.Event {
+	return &types.Event{Topic: "test", Message: []byte(message)}
+}
@@ -0,0 +1,323 @@
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+
+	"github.com/anchorfree/data-go/pkg/logger"
+	"github.com/anchorfree/data-go/pkg/types"
+)
+
+const (
+	DefaultLearnerMaxEnumValues  = 10
+	DefaultLearnerMinEnumSamples = 100
+	DefaultLearnerMaxEventTypes  = 1000
+	DefaultLearnerMaxProperties  = 100
+
+	// presenceExtension keeps the share of events with the property in the exported document
+	presenceExtension = "x-presence"
+)
+
+type LearnerConfig struct {
+	PropertyName string `yaml:"property_name"`
+	// Topics to learn from, all topics if empty
+	Topics []string `yaml:"topics"`
+	// String properties with up to MaxEnumValues distinct values are exported as enums
+	MaxEnumValues int `yaml:"max_enum_values"`
+	// Minimal number of observed values to consider a property enum-like
+	MinEnumSamples uint64 `yaml:"min_enum_samples"`
+	// Event types over the limit are ignored, protects from unbounded growth
+	MaxEventTypes int `yaml:"max_event_types"`
+	// Properties of an object over the limit, e.g. keyed by IDs, are learned together as additional properties
+	MaxProperties int `yaml:"max_properties"`
+}
+
+// Learner accumulates the shape of observed events per topic and event type
+// and exports it as a draft OpenAPI document, which SchemaManager can load.
+type Learner struct {
+	mx     sync.Mutex
+	config LearnerConfig
+	topics map[string]bool
+	// topic -> event type -> stats
+	stats map[string]map[string]*fieldStats
+	types int
+}
+
+// fieldStats describes a single value: a property, an array item or a whole event
+type fieldStats struct {
+	count      uint64
+	types      map[string]uint64
+	values     map[string]uint64
+	overflow   bool
+	properties map[string]*fieldStats
+	// properties over MaxProperties
+	additional *fieldStats
+	items      *fieldStats
+}
+
+func NewLearner(config LearnerConfig) *Learner {
+	if config.MaxEnumValues == 0 {
+		config.MaxEnumValues = DefaultLearnerMaxEnumValues
+	}
+	if config.MinEnumSamples == 0 {
+		config.MinEnumSamples = DefaultLearnerMinEnumSamples
+	}
+	if config.MaxEventTypes == 0 {
+		config.MaxEventTypes = DefaultLearnerMaxEventTypes
+	}
+	if config.MaxProperties == 0 {
+		config.MaxProperties = DefaultLearnerMaxProperties
+	}
+	l := &Learner{
+		config: config,
+		topics: make(map[string]bool, len(config.Topics)),
+		stats:  make(map[string]map[string]*fieldStats),
+	}
+	for _, topic := range config.Topics {
+		l.topics[topic] = true
+	}
+	return l
+}
+
+// Observe accumulates the event, non JSON object events are ignored
+func (l *Learner) Observe(event *types.Event) {
+	if len(l.topics) > 0 && !l.topics[event.Topic] {
+		return
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(event.Message, &data); err != nil {
+		logger.Get().Debugf("learner skipped non json event: %v", err)
+		return
+	}
+	eventType, ok := data[l.config.PropertyName].(string)
+	if !ok || openapi3.ValidateIdentifier(eventType) != nil {
+		logger.Get().Debugf("learner skipped event without valid type")
+		return
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	topicStats, found := l.stats[event.Topic]
+	if !found {
+		topicStats = make(map[string]*fieldStats)
+		l.stats[event.Topic] = topicStats
+	}
+	stats, found := topicStats[eventType]
+	if !found {
+		if l.types >= l.config.MaxEventTypes {
+			logger.Get().Debugf("learner skipped event type %s: too many event types", eventType)
+			return
+		}
+		stats = newFieldStats()
+		topicStats[eventType] = stats
+		l.types++
+	}
+	stats.observe(data, &l.config)
+}
+
+// Topics returns topics with observed events
+func (l *Learner) Topics() []string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	topics := make([]string, 0, len(l.stats))
+	for topic := range l.stats {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Swagger returns a draft document with a component schema per event type observed in the topic
+func (l *Learner) Swagger(topic string) *openapi3.Swagger {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	swagger := &openapi3.Swagger{
+		OpenAPI: "3.0.0",
+		Info: openapi3.Info{
+			Title:   topic,
+			Version: "0.0.0",
+		},
+		Paths: openapi3.Paths{},
+		Components: openapi3.Components{
+			Schemas: make(map[string]*openapi3.SchemaRef, len(l.stats[topic])),
+		},
+	}
+	for eventType, stats := range l.stats[topic] {
+		swagger.Components.Schemas[eventType] = stats.schema(l.config).NewRef()
+	}
+	return swagger
+}
+
+// Export returns the draft document of the topic as JSON
+func (l *Learner) Export(topic string) ([]byte, error) {
+	return json.Marshal(l.Swagger(topic))
+}
+
+// Reset drops everything learned so far
+func (l *Learner) Reset() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.stats = make(map[string]map[string]*fieldStats)
+	l.types = 0
+}
+
+func newFieldStats() *fieldStats {
+	return &fieldStats{
+		types:  make(map[string]uint64),
+		values: make(map[string]uint64),
+	}
+}
+
+func (fs *fieldStats) observe(value interface{}, config *LearnerConfig) {
+	fs.count++
+	switch v := value.(type) {
+	case nil:
+		fs.types["null"]++
+	case bool:
+		fs.types["boolean"]++
+	case float64:
+		if v == math.Trunc(v) {
+			fs.types["integer"]++
+		} else {
+			fs.types["number"]++
+		}
+	case string:
+		fs.types["string"]++
+		if fs.overflow {
+			break
+		}
+		if _, found := fs.values[v]; !found && len(fs.values) >= config.MaxEnumValues {
+			fs.overflow = true
+			fs.values = nil
+			break
+		}
+		fs.values[v]++
+	case []interface{}:
+		fs.types["array"]++
+		if fs.items == nil {
+			fs.items = newFieldStats()
+		}
+		for _, item := range v {
+			fs.items.observe(item, config)
+		}
+	case map[string]interface{}:
+		fs.types["object"]++
+		if fs.properties == nil {
+			fs.properties = make(map[string]*fieldStats)
+		}
+		for name, property := range v {
+			stats, found := fs.properties[name]
+			if !found && len(fs.properties) >= config.MaxProperties {
+				if fs.additional == nil {
+					fs.additional = newFieldStats()
+				}
+				stats = fs.additional
+			} else if !found {
+				stats = newFieldStats()
+				fs.properties[name] = stats
+			}
+			stats.observe(property, config)
+		}
+	}
+}
+
+// dominantType returns the most frequent non null type, integers are widened
+// to numbers if both were seen
+func (fs *fieldStats) dominantType() string {
+	if fs.types["integer"] > 0 && fs.types["number"] > 0 {
+		return "number"
+	}
+	var (
+		dominant string
+		max      uint64
+	)
+	for _, name := range []string{"object", "array", "string", "number", "integer", "boolean"} {
+		if fs.types[name] > max {
+			dominant, max = name, fs.types[name]
+		}
+	}
+	return dominant
+}
+
+func (fs *fieldStats) schema(config LearnerConfig) *openapi3.Schema {
+	schema := openapi3.NewSchema()
+	schema.Type = fs.dominantType()
+	schema.Nullable = fs.types["null"] > 0
+	switch schema.Type {
+	case "string":
+		if !fs.overflow && fs.types["string"] >= config.MinEnumSamples {
+			values := make([]string, 0, len(fs.values))
+			for value := range fs.values {
+				values = append(values, value)
+			}
+			sort.Strings(values)
+			for _, value := range values {
+				schema.Enum = append(schema.Enum, value)
+			}
+		}
+	case "array":
+		if fs.items != nil && fs.items.count > 0 {
+			schema.Items = fs.items.schema(config).NewRef()
+		}
+	case "object":
+		objects := fs.types["object"]
+		schema.Properties = make(map[string]*openapi3.SchemaRef, len(fs.properties))
+		for name, stats := range fs.properties {
+			property := stats.schema(config)
+			property.Extensions = map[string]interface{}{
+				presenceExtension: float64(stats.count) / float64(objects),
+			}
+			schema.Properties[name] = property.NewRef()
+			if stats.count >= objects {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		sort.Strings(schema.Required)
+		if fs.additional != nil {
+			schema.AdditionalProperties = fs.additional.schema(config).NewRef()
+		}
+	}
+	return schema
+}
+
+type LearnerIterator struct {
+	learner *Learner
+
+	iter  types.EventIterator
+	event *types.Event
+	err   error
+}
+
+var _ types.EventIterator = (*LearnerIterator)(nil)
+
+// NewIterator passes events through unchanged while learning from them
+func (l *Learner) NewIterator(iterator types.EventIterator) *LearnerIterator {
+	return &LearnerIterator{
+		learner: l,
+		iter:    iterator,
+	}
+}
+
+func (li *LearnerIterator) Next() bool {
+	if !li.iter.Next() {
+		li.err = li.iter.Err()
+		return false
+	}
+
+	li.event = li.iter.At()
+	li.learner.Observe(li.event)
+
+	return true
+}
+
+func (li *LearnerIterator) At() *types.Event {
+	return li.event
+}
+
+func (li *LearnerIterator) Err() error {
+	return li.err
+}
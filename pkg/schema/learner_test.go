@@ -0,0 +1,92 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/line_offset_reader"
+)
+
+func TestLearner(t *testing.T) {
+	var lines []string
+	for i := 0; i < 10; i++ {
+		lines = append(lines,
+			fmt.Sprintf(`{"event":"app_start","ts":%d,"platform":"%s","payload":{"seq":%d,"tags":["a"]}}`, i, []string{"ios", "android"}[i%2], i),
+			fmt.Sprintf(`{"event":"app_stop","duration":%d.5,"reason":"r%d","error":null}`, i, i),
+		)
+	}
+	lines = append(lines, `{"event":"app_start","ts":10,"platform":"web","payload":{"seq":10,"tags":[]},"debug":true}`, `not json`, `{"no_event":1}`)
+
+	l := NewLearner(LearnerConfig{PropertyName: "event", MaxEnumValues: 3, MinEnumSamples: 5})
+	iter := l.NewIterator(line_offset_reader.NewIterator(bytes.NewReader([]byte(strings.Join(lines, "\n"))), "test"))
+	count := 0
+	for iter.Next() {
+		count++
+	}
+	assert.Equal(t, len(lines), count, "learner must pass all events through")
+	assert.Equal(t, []string{"test"}, l.Topics())
+
+	swagger := l.Swagger("test")
+	require.Len(t, swagger.Components.Schemas, 2)
+
+	start := swagger.Components.Schemas["app_start"].Value
+	assert.Equal(t, "object", start.Type)
+	assert.Equal(t, []string{"event", "payload", "platform", "ts"}, start.Required)
+	assert.Equal(t, "integer", start.Properties["ts"].Value.Type)
+	assert.Equal(t, []interface{}{"android", "ios", "web"}, start.Properties["platform"].Value.Enum)
+	assert.Equal(t, "boolean", start.Properties["debug"].Value.Type)
+	assert.InDelta(t, 1.0/11, start.Properties["debug"].Value.Extensions[presenceExtension], 0.0001)
+	payload := start.Properties["payload"].Value
+	assert.Equal(t, "integer", payload.Properties["seq"].Value.Type)
+	assert.Equal(t, "string", payload.Properties["tags"].Value.Items.Value.Type)
+
+	stop := swagger.Components.Schemas["app_stop"].Value
+	assert.Equal(t, "number", stop.Properties["duration"].Value.Type)
+	assert.Nil(t, stop.Properties["reason"].Value.Enum, "too many distinct values for enum")
+	assert.True(t, stop.Properties["error"].Value.Nullable)
+
+	exported, err := l.Export("test")
+	require.NoError(t, err)
+
+	sm := NewSchemaManager(Config{PropertyName: "event", ValidateTopics: []string{"test"}})
+	require.NoError(t, sm.updateConfig(exported))
+	for _, line := range lines[:20] {
+		ok, err := sm.Validate(*newEvent(line))
+		assert.Truef(t, ok, "%s: %v", line, err)
+	}
+	ok, _ := sm.Validate(*newEvent(`{"event":"app_start","ts":1}`))
+	assert.False(t, ok, "required properties are missing")
+
+	l.Reset()
+	assert.Empty(t, l.Topics())
+}
+
+func TestLearner_Limits(t *testing.T) {
+	l := NewLearner(LearnerConfig{PropertyName: "event", Topics: []string{"test"}, MaxEventTypes: 1})
+	l.Observe(newEvent(`{"event":"first"}`))
+	l.Observe(newEvent(`{"event":"second"}`))
+	l.Observe(newEvent(`{"event":"bad type!"}`))
+	other := newEvent(`{"event":"first"}`)
+	other.Topic = "other"
+	l.Observe(other)
+
+	assert.Equal(t, []string{"test"}, l.Topics())
+	schemas := l.Swagger("test").Components.Schemas
+	assert.Len(t, schemas, 1)
+	assert.Contains(t, schemas, "first")
+
+	// properties over the limit are learned as additional properties
+	l = NewLearner(LearnerConfig{PropertyName: "event", MaxProperties: 2})
+	for i := 0; i < 10; i++ {
+		l.Observe(newEvent(fmt.Sprintf(`{"event":"scores","by_user":{"u%d":%d}}`, i, i)))
+	}
+	byUser := l.Swagger("test").Components.Schemas["scores"].Value.Properties["by_user"].Value
+	assert.Len(t, byUser.Properties, 2)
+	require.NotNil(t, byUser.AdditionalProperties)
+	assert.Equal(t, "integer", byUser.AdditionalProperties.Value.Type)
+}
@@ -3,63 +3,199 @@ package schema
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/anchorfree/data-go/pkg/consul"
 	"github.com/anchorfree/data-go/pkg/logger"
 	"github.com/anchorfree/data-go/pkg/types"
 )
 
+var ErrUnknownEventType = errors.New("no schema for event type")
+
 type SchemaManager struct {
-	mx     sync.Mutex
+	mx     sync.RWMutex
 	config *Config
+	// shared document, applied to topics without their own one
 	schema *openapi3.Swagger
+	// documents bound to a single topic
+	topicSchemas map[string]*openapi3.Swagger
+	// schemas resolved per topic, rebuilt on every document update and never modified in place
+	defaultSet schemaSet
+	topicSets  map[string]schemaSet
 
-	validateTopics map[string]bool
+	validateTopics    map[string]bool
+	repairTopics      map[string]bool
+	validationCounter *prometheus.CounterVec
+	repairCounter     *prometheus.CounterVec
 }
 
+// schemaSet maps event type to its schema
+type schemaSet map[string]*openapi3.Schema
+
 func NewSchemaManager(config Config) *SchemaManager {
 	sm := &SchemaManager{
-		config:         &config,
-		validateTopics: make(map[string]bool, len(config.ValidateTopics)),
+		config:            &config,
+		topicSchemas:      make(map[string]*openapi3.Swagger, len(config.Topics)),
+		topicSets:         make(map[string]schemaSet, len(config.Topics)),
+		validateTopics:    make(map[string]bool, len(config.ValidateTopics)+len(config.Topics)),
+		repairTopics:      make(map[string]bool),
+		validationCounter: newValidationCounter(),
+		repairCounter:     newRepairCounter(),
 	}
 	for _, item := range sm.config.ValidateTopics {
 		sm.validateTopics[item] = true
 	}
+	for topic, topicConfig := range sm.config.Topics {
+		sm.validateTopics[topic] = true
+		if topicConfig.Repair {
+			sm.repairTopics[topic] = true
+		}
+	}
 	logger.Get().Infof("topics for validation: %#v", sm.validateTopics)
+	if len(sm.repairTopics) > 0 {
+		logger.Get().Infof("topics for repair: %#v", sm.repairTopics)
+	}
 	return sm
 }
 
+// Validate checks the event against schemas of its topic.
+// It returns ErrUnknownEventType (wrapped) if there is no schema for the event type
 func (sm *SchemaManager) Validate(event types.Event) (bool, error) {
+	_, err := sm.validate(event)
+	return err == nil, err
+}
+
+// validate returns the name of the matched schema along with the validation error
+func (sm *SchemaManager) validate(event types.Event) (string, error) {
+	return sm.validateWith(sm.schemaSet(event.Topic), &event, false)
+}
+
+// validateWith validates the event against the set. With repair enabled the event
+// is repaired first and its message is replaced if the repaired event is valid
+func (sm *SchemaManager) validateWith(set schemaSet, event *types.Event, repair bool) (string, error) {
 	var data map[string]interface{}
 	if err := json.Unmarshal(event.Message, &data); err != nil {
-		return false, err
-	}
-	for key, value := range sm.schema.Components.Schemas {
-		if data[sm.config.PropertyName] == key {
-			err := value.Value.VisitJSON(data)
-			if err != nil {
-				logger.Get().Debugf("failed validation for schema event type: %#v", key)
-				return false, err
+		return "", err
+	}
+	eventType, _ := data[sm.config.PropertyName].(string)
+	schema, ok := set[eventType]
+	if !ok {
+		logger.Get().Debugf("no schema for event")
+		return "", fmt.Errorf("%w: %v", ErrUnknownEventType, data[sm.config.PropertyName])
+	}
+	r := repairs{}
+	message := event.Message
+	if repair {
+		repaired, err := repairMessage(schema, message, r)
+		if err != nil {
+			return eventType, err
+		}
+		if len(r) > 0 {
+			message = repaired
+			data = nil
+			if err := json.Unmarshal(message, &data); err != nil {
+				return eventType, err
 			}
-			logger.Get().Debugf("successful validation for schema event type %#v", key)
-			return true, nil
 		}
 	}
-	logger.Get().Debugf("no schema for event")
-	return false, nil
+	if err := schema.VisitJSON(data); err != nil {
+		logger.Get().Debugf("failed validation for schema event type: %#v", eventType)
+		if len(r) > 0 {
+			sm.observeRepairs(event.Topic, eventType, r, resultInvalid)
+		}
+		return eventType, err
+	}
+	if len(r) > 0 {
+		logger.Get().Debugf("repaired event type %#v: %v", eventType, r)
+		event.Message = message
+		sm.observeRepairs(event.Topic, eventType, r, resultValid)
+	}
+	logger.Get().Debugf("successful validation for schema event type %#v", eventType)
+	return eventType, nil
 }
 
-func (sm *SchemaManager) IsTopicValidated(topic string) {
+func (sm *SchemaManager) IsTopicValidated(topic string) bool {
+	return sm.validateTopics[topic]
+}
 
+func (sm *SchemaManager) IsTopicRepaired(topic string) bool {
+	return sm.repairTopics[topic]
 }
 
+// schemaSet returns schemas the topic is validated against, nil if no document is loaded for it
+func (sm *SchemaManager) schemaSet(topic string) schemaSet {
+	sm.mx.RLock()
+	defer sm.mx.RUnlock()
+	if set, ok := sm.topicSets[topic]; ok {
+		return set
+	}
+	return sm.defaultSet
+}
+
+// ApplySwagger replaces the shared document
 func (sm *SchemaManager) ApplySwagger(schema *openapi3.Swagger) {
 	sm.mx.Lock()
 	defer sm.mx.Unlock()
 	sm.schema = schema
+	sm.rebuildSets()
+}
+
+// ApplyTopicSwagger replaces the document bound to the topic,
+// nil makes the topic fall back to the shared document
+func (sm *SchemaManager) ApplyTopicSwagger(topic string, schema *openapi3.Swagger) {
+	sm.mx.Lock()
+	defer sm.mx.Unlock()
+	if schema == nil {
+		delete(sm.topicSchemas, topic)
+	} else {
+		sm.topicSchemas[topic] = schema
+	}
+	sm.rebuildSets()
+}
+
+// rebuildSets must be called with mx locked
+func (sm *SchemaManager) rebuildSets() {
+	sm.defaultSet = newSchemaSet(sm.schema, nil)
+	topicSets := make(map[string]schemaSet, len(sm.config.Topics))
+	for topic, topicConfig := range sm.config.Topics {
+		doc, found := sm.topicSchemas[topic]
+		if !found {
+			doc = sm.schema
+		}
+		topicSets[topic] = newSchemaSet(doc, topicConfig.Schemas)
+	}
+	sm.topicSets = topicSets
+}
+
+func newSchemaSet(doc *openapi3.Swagger, names []string) schemaSet {
+	if doc == nil {
+		return nil
+	}
+	set := make(schemaSet, len(doc.Components.Schemas))
+	for name, ref := range doc.Components.Schemas {
+		if ref == nil || ref.Value == nil {
+			continue
+		}
+		set[name] = ref.Value
+	}
+	if len(names) == 0 {
+		return set
+	}
+	subset := make(schemaSet, len(names))
+	for _, name := range names {
+		if schema, ok := set[name]; ok {
+			subset[name] = schema
+		} else {
+			logger.Get().Warnf("schema %s is not found in the document", name)
+		}
+	}
+	return subset
 }
 
 func (sm *SchemaManager) RunConfigWatcher() error {
@@ -68,27 +204,95 @@ func (sm *SchemaManager) RunConfigWatcher() error {
 		return err
 	}
 	watcher := consul.NewWatcher(client, nil)
-	watcher.Watch(sm.config.ConsulKeyPath, sm.updateConfig)
+	if len(sm.config.ConsulKeyPath) > 0 {
+		watcher.Watch(sm.config.ConsulKeyPath, sm.updateConfig)
+	}
+	for topic, topicConfig := range sm.config.Topics {
+		if len(topicConfig.ConsulKeyPath) == 0 {
+			continue
+		}
+		topic := topic
+		watcher.Watch(topicConfig.ConsulKeyPath, func(rawConfig []byte) error {
+			return sm.updateTopicConfig(topic, rawConfig)
+		})
+	}
 	return nil
 }
 
 func (sm *SchemaManager) updateConfig(rawConfig []byte) error {
-	swagger, err := openapi3.NewSwaggerLoader().LoadSwaggerFromData(rawConfig)
+	swagger, err := loadSwagger(rawConfig)
 	if err != nil {
 		return err
 	}
+	sm.ApplySwagger(swagger)
+	logger.Get().Info("OpenAPI schema has been successfully updated")
+	return nil
+}
 
-	if err := swagger.Validate(context.Background()); err != nil {
+func (sm *SchemaManager) updateTopicConfig(topic string, rawConfig []byte) error {
+	swagger, err := loadSwagger(rawConfig)
+	if err != nil {
 		return err
 	}
-	sm.ApplySwagger(swagger)
-	logger.Get().Info("OpenAPI schema has been successfully updated")
+	sm.ApplyTopicSwagger(topic, swagger)
+	logger.Get().Infof("OpenAPI schema for topic %s has been successfully updated", topic)
 	return nil
 }
 
+func loadSwagger(rawConfig []byte) (*openapi3.Swagger, error) {
+	swagger, err := openapi3.NewSwaggerLoader().LoadSwaggerFromData(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := swagger.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return swagger, nil
+}
+
 func (sm *SchemaManager) GetInvalidMessagesTopic() string {
 	if len(sm.config.InvalidMessagesTopic) > 0 {
 		return sm.config.InvalidMessagesTopic
 	}
 	return "malformed"
 }
+
+func (sm *SchemaManager) GetUnknownTypeTopic() string {
+	if len(sm.config.UnknownTypeTopic) > 0 {
+		return sm.config.UnknownTypeTopic
+	}
+	return "unknown_type"
+}
+
+func (sm *SchemaManager) GetUnknownTypePolicy() string {
+	switch sm.config.UnknownTypePolicy {
+	case UnknownTypePass, UnknownTypeRoute:
+		return sm.config.UnknownTypePolicy
+	}
+	return UnknownTypeReject
+}
+
+// ErrorDetails returns a single line description of the validation error,
+// without schema and value dumps
+func ErrorDetails(err error) string {
+	if err == nil {
+		return ""
+	}
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		reason := schemaErr.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("doesn't match schema %q", schemaErr.SchemaField)
+		}
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+			reason = "/" + strings.Join(pointer, "/") + ": " + reason
+		}
+		return sanitizeDetails(reason)
+	}
+	return sanitizeDetails(err.Error())
+}
+
+func sanitizeDetails(details string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(details)
+}
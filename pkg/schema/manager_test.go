@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTopicSwagger = []byte(`
+openapi: 3.0.0
+info:
+  title: topic
+  version: 1.0.0
+paths: {}
+components:
+  schemas:
+    app_stop:
+      type: object
+      required:
+        - reason
+      properties:
+        reason:
+          type: string
+`)
+
+func TestSchemaManager_TopicBindings(t *testing.T) {
+	sm := NewSchemaManager(Config{
+		PropertyName:   "event",
+		ValidateTopics: []string{"shared"},
+		Topics: map[string]TopicConfig{
+			"own":    {},
+			"subset": {Schemas: []string{"app_stop"}},
+		},
+	})
+	assert.True(t, sm.IsTopicValidated("shared"))
+	assert.True(t, sm.IsTopicValidated("own"))
+	assert.False(t, sm.IsTopicValidated("other"))
+
+	require.NoError(t, sm.updateConfig(testSwagger))
+	require.NoError(t, sm.updateTopicConfig("own", testTopicSwagger))
+
+	validate := func(topic string, message string) error {
+		event := newEvent(message)
+		event.Topic = topic
+		_, err := sm.Validate(*event)
+		return err
+	}
+
+	assert.NoError(t, validate("shared", `{"event":"app_start","ts":1}`))
+	assert.True(t, errors.Is(validate("shared", `{"event":"app_stop","reason":"x"}`), ErrUnknownEventType))
+
+	assert.True(t, errors.Is(validate("own", `{"event":"app_start","ts":1}`), ErrUnknownEventType))
+	assert.NoError(t, validate("own", `{"event":"app_stop","reason":"x"}`))
+
+	// only app_stop is bound to the topic, app_start of the shared document is ignored
+	assert.True(t, errors.Is(validate("subset", `{"event":"app_start","ts":1}`), ErrUnknownEventType))
+
+	sm.ApplyTopicSwagger("own", nil)
+	assert.NoError(t, validate("own", `{"event":"app_start","ts":1}`))
+}
+
+func TestSchemaManager_HotSwap(t *testing.T) {
+	sm := NewSchemaManager(Config{
+		PropertyName:   "event",
+		ValidateTopics: []string{"test"},
+		Topics:         map[string]TopicConfig{"own": {}},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				raw := fmt.Sprintf(`{"event":"app_start","ts":%d}`, j)
+				collectEvents(sm, raw)
+				_, _ = sm.Validate(*newEvent(raw))
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			assert.NoError(t, sm.updateConfig(testSwagger))
+			assert.NoError(t, sm.updateTopicConfig("own", testTopicSwagger))
+			sm.ApplyTopicSwagger("own", nil)
+		}
+	}()
+	wg.Wait()
+
+	ok, err := sm.Validate(*newEvent(`{"event":"app_start","ts":1}`))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultValid       = "valid"
+	resultInvalid     = "invalid"
+	resultUnknownType = "unknown_type"
+	resultMalformed   = "malformed"
+)
+
+func newValidationCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "schema_validation_events_total",
+			Help: "Number of events processed by schema validation, by topic, event type and result",
+		},
+		[]string{"topic", "event_type", "result"},
+	)
+}
+
+func newRepairCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "schema_repairs_total",
+			Help: "Number of repairs applied to events to match the schema, by topic, event type, repair kind and whether the repaired event is valid",
+		},
+		[]string{"topic", "event_type", "repair", "result"},
+	)
+}
+
+func (sm *SchemaManager) RegisterMetrics(prom *prometheus.Registry) {
+	prom.MustRegister(sm.validationCounter)
+	prom.MustRegister(sm.repairCounter)
+}
+
+func (sm *SchemaManager) observe(topic string, eventType string, result string) {
+	if eventType == "" {
+		eventType = "-"
+	}
+	sm.validationCounter.With(prometheus.Labels{
+		"topic":      topic,
+		"event_type": eventType,
+		"result":     result,
+	}).Inc()
+}
+
+func (sm *SchemaManager) observeRepairs(topic string, eventType string, r repairs, result string) {
+	for kind, count := range r {
+		sm.repairCounter.With(prometheus.Labels{
+			"topic":      topic,
+			"event_type": eventType,
+			"repair":     kind,
+			"result":     result,
+		}).Add(float64(count))
+	}
+}
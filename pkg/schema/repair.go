@@ -0,0 +1,150 @@
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	repairCoerce  = "coerce"
+	repairDefault = "default"
+	repairDrop    = "drop"
+)
+
+var (
+	repairParsers fastjson.ParserPool
+	repairArenas  fastjson.ArenaPool
+)
+
+// repairs counts applied repairs by kind
+type repairs map[string]int
+
+func (r repairs) add(kind string) {
+	r[kind]++
+}
+
+// repairMessage repairs the event in place, untouched values keep their bytes and order.
+// It returns the repaired message, or the message as is if nothing was repaired.
+func repairMessage(schema *openapi3.Schema, message []byte, r repairs) ([]byte, error) {
+	parser := repairParsers.Get()
+	defer repairParsers.Put(parser)
+	arena := repairArenas.Get()
+	defer repairArenas.Put(arena)
+
+	root, err := parser.ParseBytes(message)
+	if err != nil {
+		return nil, err
+	}
+	if root.Type() != fastjson.TypeObject {
+		return message, nil
+	}
+	repairObject(schema, root, r, arena)
+	if len(r) == 0 {
+		return message, nil
+	}
+	return root.MarshalTo(nil), nil
+}
+
+// repairValue makes value match the schema where it can be done without guessing:
+// numeric strings become numbers, missing properties get their default values
+// and properties are dropped if additionalProperties is false.
+// Objects and arrays are modified in place, a replacement is returned for other changed values.
+func repairValue(schema *openapi3.Schema, value *fastjson.Value, r repairs, arena *fastjson.Arena) *fastjson.Value {
+	if schema == nil {
+		return nil
+	}
+	switch value.Type() {
+	case fastjson.TypeObject:
+		repairObject(schema, value, r, arena)
+	case fastjson.TypeArray:
+		if schema.Items != nil {
+			for i, item := range value.GetArray() {
+				if replacement := repairValue(schema.Items.Value, item, r, arena); replacement != nil {
+					value.SetArrayItem(i, replacement)
+				}
+			}
+		}
+	case fastjson.TypeString:
+		if number, ok := coerceNumber(schema.Type, string(value.GetStringBytes())); ok {
+			r.add(repairCoerce)
+			return arena.NewNumberString(number)
+		}
+	}
+	return nil
+}
+
+func repairObject(schema *openapi3.Schema, value *fastjson.Value, r repairs, arena *fastjson.Arena) {
+	o := value.GetObject()
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	// defaults are added in a stable order
+	sort.Strings(names)
+	for _, name := range names {
+		ref := schema.Properties[name]
+		if ref == nil || ref.Value == nil {
+			continue
+		}
+		if property := o.Get(name); property != nil {
+			if replacement := repairValue(ref.Value, property, r, arena); replacement != nil {
+				o.Set(name, replacement)
+			}
+		} else if ref.Value.Default != nil {
+			if defaultValue, ok := jsonValue(ref.Value.Default); ok {
+				o.Set(name, defaultValue)
+				r.add(repairDefault)
+			}
+		}
+	}
+	allowed := schema.AdditionalPropertiesAllowed
+	if allowed == nil || *allowed || schema.AdditionalProperties != nil {
+		return
+	}
+	var drop []string
+	o.Visit(func(key []byte, _ *fastjson.Value) {
+		if _, found := schema.Properties[string(key)]; !found {
+			drop = append(drop, string(key))
+		}
+	})
+	for _, name := range drop {
+		o.Del(name)
+		r.add(repairDrop)
+	}
+}
+
+// jsonValue converts a schema default to a JSON value
+func jsonValue(value interface{}) (*fastjson.Value, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	v, err := fastjson.ParseBytes(data)
+	return v, err == nil
+}
+
+// coerceNumber returns the JSON number of a numeric string, the text is kept
+// if it is a JSON number already, so large integers don't lose precision
+func coerceNumber(schemaType string, value string) (string, bool) {
+	if schemaType != "number" && schemaType != "integer" {
+		return "", false
+	}
+	text := strings.TrimSpace(value)
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return "", false
+	}
+	if schemaType == "integer" && number != math.Trunc(number) {
+		return "", false
+	}
+	if v, err := fastjson.Parse(text); err == nil && v.Type() == fastjson.TypeNumber {
+		return text, true
+	}
+	return strconv.FormatFloat(number, 'g', -1, 64), true
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anchorfree/data-go/pkg/promutils"
+)
+
+var testRepairSwagger = []byte(`
+openapi: 3.0.0
+info:
+  title: repair
+  version: 1.0.0
+paths: {}
+components:
+  schemas:
+    purchase:
+      type: object
+      additionalProperties: false
+      required:
+        - event
+        - amount
+        - currency
+      properties:
+        event:
+          type: string
+        amount:
+          type: number
+        currency:
+          type: string
+          default: USD
+        items:
+          type: array
+          items:
+            type: object
+            properties:
+              qty:
+                type: integer
+        payload:
+          type: object
+`)
+
+func TestEventIterator_Repair(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		topic    string
+		expected string
+	}{
+		{
+			name:     "valid",
+			raw:      `{"event":"purchase","amount":1.5,"currency":"EUR"}`,
+			topic:    "test",
+			expected: `{"event":"purchase","amount":1.5,"currency":"EUR"}`,
+		},
+		{
+			name:     "coerce and default",
+			raw:      `{"event":"purchase","amount":"1.5","items":[{"qty":"2"}]}`,
+			topic:    "test",
+			expected: `{"event":"purchase","amount":1.5,"items":[{"qty":2}],"currency":"USD"}`,
+		},
+		{
+			name:     "drop additional properties",
+			raw:      `{"event":"purchase","amount":2,"currency":"EUR","debug":"<b>","payload":{"keep":1}}`,
+			topic:    "test",
+			expected: `{"event":"purchase","amount":2,"currency":"EUR","payload":{"keep":1}}`,
+		},
+		{
+			name:     "untouched values kept as is",
+			raw:      `{"event":"purchase","amount":"3","payload":{"id":9007199254740993,"price":1.10}}`,
+			topic:    "test",
+			expected: `{"event":"purchase","amount":3,"payload":{"id":9007199254740993,"price":1.10},"currency":"USD"}`,
+		},
+		{
+			name:     "not a number",
+			raw:      `{"event":"purchase","amount":"many"}`,
+			topic:    "malformed",
+			expected: "test\t" + `{"event":"purchase","amount":"many"}`,
+		},
+		{
+			name:     "not an integer",
+			raw:      `{"event":"purchase","amount":1,"items":[{"qty":"2.5"}]}`,
+			topic:    "malformed",
+			expected: "test\t" + `{"event":"purchase","amount":1,"items":[{"qty":"2.5"}]}`,
+		},
+	}
+	for _, test := range tests {
+		sm := NewSchemaManager(Config{
+			PropertyName: "event",
+			Topics:       map[string]TopicConfig{"test": {Repair: true}},
+		})
+		require.NoError(t, sm.updateConfig(testRepairSwagger))
+		topics, messages := collectEvents(sm, test.raw)
+		assert.Equalf(t, []string{test.topic}, topics, "test %s", test.name)
+		assert.Equalf(t, []string{test.expected}, messages, "test %s", test.name)
+	}
+}
+
+func TestEventIterator_RepairMetrics(t *testing.T) {
+	sm := NewSchemaManager(Config{
+		PropertyName: "event",
+		Topics:       map[string]TopicConfig{"test": {Repair: true}},
+	})
+	require.NoError(t, sm.updateConfig(testRepairSwagger))
+	collectEvents(sm, `{"event":"purchase","amount":"1","extra":1,"more":2}`)
+	// repairs of events still invalid are counted too
+	collectEvents(sm, `{"event":"purchase","amount":"many","extra":1}`)
+
+	expect := `# HELP schema_repairs_total Number of repairs applied to events to match the schema, by topic, event type, repair kind and whether the repaired event is valid
+# TYPE schema_repairs_total counter
+schema_repairs_total{event_type="purchase",repair="coerce",result="valid",topic="test"} 1
+schema_repairs_total{event_type="purchase",repair="default",result="invalid",topic="test"} 1
+schema_repairs_total{event_type="purchase",repair="default",result="valid",topic="test"} 1
+schema_repairs_total{event_type="purchase",repair="drop",result="invalid",topic="test"} 1
+schema_repairs_total{event_type="purchase",repair="drop",result="valid",topic="test"} 2
+`
+	rendered, err := promutils.Collect(sm.repairCounter)
+	assert.NoError(t, err)
+	assert.Equal(t, expect, rendered)
+}
+
+func TestEventIterator_NoRepair(t *testing.T) {
+	sm := NewSchemaManager(Config{
+		PropertyName:   "event",
+		ValidateTopics: []string{"test"},
+	})
+	require.NoError(t, sm.updateConfig(testRepairSwagger))
+	topics, _ := collectEvents(sm, `{"event":"purchase","amount":"1.5","currency":"EUR"}`)
+	assert.Equal(t, []string{"malformed"}, topics)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"net"
+)
+
+// SpecialPurposeRange is a network of the IANA IPv4 and IPv6 special-purpose address registries
+type SpecialPurposeRange struct {
+	Name    string
+	Network *net.IPNet
+}
+
+// SpecialPurposeRanges lists networks which can't be the public address of a client:
+// the blocks not globally reachable, the NAT64 prefixes and multicast.
+// IPv4-mapped IPv6 addresses are matched against the IPv4 blocks.
+var SpecialPurposeRanges = []SpecialPurposeRange{
+	newSpecialPurposeRange("this_network", "0.0.0.0/8"),
+	newSpecialPurposeRange("private", "10.0.0.0/8"),
+	newSpecialPurposeRange("shared_address_space", "100.64.0.0/10"),
+	newSpecialPurposeRange("loopback", "127.0.0.0/8"),
+	newSpecialPurposeRange("link_local", "169.254.0.0/16"),
+	newSpecialPurposeRange("private", "172.16.0.0/12"),
+	newSpecialPurposeRange("ietf_protocol_assignments", "192.0.0.0/24"),
+	newSpecialPurposeRange("documentation", "192.0.2.0/24"),
+	newSpecialPurposeRange("6to4_relay_anycast", "192.88.99.0/24"),
+	newSpecialPurposeRange("private", "192.168.0.0/16"),
+	newSpecialPurposeRange("benchmarking", "198.18.0.0/15"),
+	newSpecialPurposeRange("documentation", "198.51.100.0/24"),
+	newSpecialPurposeRange("documentation", "203.0.113.0/24"),
+	newSpecialPurposeRange("multicast", "224.0.0.0/4"),
+	newSpecialPurposeRange("reserved", "240.0.0.0/4"),
+	newSpecialPurposeRange("limited_broadcast", "255.255.255.255/32"),
+
+	newSpecialPurposeRange("unspecified", "::/128"),
+	newSpecialPurposeRange("loopback", "::1/128"),
+	newSpecialPurposeRange("nat64", "64:ff9b::/96"),
+	newSpecialPurposeRange("nat64_local", "64:ff9b:1::/48"),
+	newSpecialPurposeRange("discard_only", "100::/64"),
+	newSpecialPurposeRange("benchmarking", "2001:2::/48"),
+	newSpecialPurposeRange("documentation", "2001:db8::/32"),
+	newSpecialPurposeRange("documentation", "3fff::/20"),
+	newSpecialPurposeRange("srv6_sids", "5f00::/16"),
+	newSpecialPurposeRange("unique_local", "fc00::/7"),
+	newSpecialPurposeRange("link_local", "fe80::/10"),
+	newSpecialPurposeRange("multicast", "ff00::/8"),
+}
+
+func newSpecialPurposeRange(name string, cidr string) SpecialPurposeRange {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return SpecialPurposeRange{Name: name, Network: network}
+}
+
+// SpecialPurpose returns the name of the special-purpose range containing the IP
+func SpecialPurpose(ip net.IP) (string, bool) {
+	for _, r := range SpecialPurposeRanges {
+		if r.Network.Contains(ip) {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// IsSpecialPurpose reports whether the IP is in a special-purpose range
+func IsSpecialPurpose(ip net.IP) bool {
+	_, found := SpecialPurpose(ip)
+	return found
+}
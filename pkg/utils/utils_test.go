@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net"
 	"net/http"
 	"testing"
 
@@ -99,3 +100,31 @@ func TestGetHeaderList(t *testing.T) {
 		}
 	}
 }
+
+func TestSpecialPurpose(t *testing.T) {
+	tests := map[string]string{
+		"10.1.2.3":           "private",
+		"100.64.0.1":         "shared_address_space",
+		"100.127.255.255":    "shared_address_space",
+		"169.254.1.1":        "link_local",
+		"192.0.2.1":          "documentation",
+		"203.0.113.9":        "documentation",
+		"::ffff:192.168.1.1": "private",
+		"fd12:3456::1":       "unique_local",
+		"fe80::1":            "link_local",
+		"2001:db8::1":        "documentation",
+		"64:ff9b::808:808":   "nat64",
+		"::1":                "loopback",
+		"ff02::1":            "multicast",
+		"100.128.0.1":        "",
+		"8.8.8.8":            "",
+		"2a00:1450::1":       "",
+		"2002:c000:201::1":   "",
+	}
+	for address, expected := range tests {
+		name, found := SpecialPurpose(net.ParseIP(address))
+		assert.Equal(t, expected, name, address)
+		assert.Equal(t, expected != "", found, address)
+		assert.Equal(t, expected != "", IsSpecialPurpose(net.ParseIP(address)), address)
+	}
+}